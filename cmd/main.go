@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,12 +22,22 @@ import (
 
 	"github.com/ecoderat/dispatch-go/internal/controller"
 	"github.com/ecoderat/dispatch-go/internal/driver"
+	"github.com/ecoderat/dispatch-go/internal/leader"
+	"github.com/ecoderat/dispatch-go/internal/listener"
+	"github.com/ecoderat/dispatch-go/internal/migration"
 	"github.com/ecoderat/dispatch-go/internal/model"
 	"github.com/ecoderat/dispatch-go/internal/repository"
 	"github.com/ecoderat/dispatch-go/internal/service/message"
 	"github.com/ecoderat/dispatch-go/internal/service/scheduler"
 )
 
+// bodyLimit allows batch enqueue requests with up to message.MaxBatchSize items.
+const bodyLimit = 64 * 1024 * 1024
+
+// defaultShutdownTimeout bounds how long a graceful shutdown waits for
+// in-flight requests and sends.
+const defaultShutdownTimeout = 30 * time.Second
+
 var (
 	// Environment variables
 	postgresConnectionString string
@@ -30,14 +45,18 @@ var (
 
 	// Command-line flags
 	fillData bool
+	interval time.Duration
 
 	// Custom error messages
 	ErrDBConnection    = errors.New("failed to connect to the database")
 	ErrDBMigration     = errors.New("failed to migrate database schema")
+	ErrSchemaOutdated  = errors.New("database schema is not up to date")
+	ErrMigrateUsage    = errors.New("usage: dispatch-go migrate up|down|status")
 	ErrDBFillDummyData = errors.New("failed to fill dummy database data")
 	ErrSchedulerStart  = errors.New("failed to start scheduler")
 	ErrLoadEnv         = errors.New("failed to load environment variables from .env file")
 	ErrMissingEnvVars  = errors.New("required environment variables are not set")
+	ErrInvalidEnvVar   = errors.New("invalid environment variable")
 )
 
 func main() {
@@ -47,6 +66,7 @@ func main() {
 	logger.SetLevel(logrus.InfoLevel)
 
 	flag.BoolVar(&fillData, "fill", false, "If set, fills the database with predefined data")
+	flag.DurationVar(&interval, "interval", 0, "Time between scheduled dispatch runs, overrides DISPATCH_INTERVAL")
 	flag.Parse()
 
 	if err := loadEnv(logger); err != nil {
@@ -54,12 +74,83 @@ func main() {
 	}
 
 	postgresConnectionString = os.Getenv("POSTGRES_CONN_STRING")
+	if flag.Arg(0) == "migrate" {
+		if postgresConnectionString == "" {
+			logger.Fatal(ErrMissingEnvVars, ". POSTGRES_CONN_STRING must be set.")
+		}
+		if err := runMigrate(postgresConnectionString, flag.Args()[1:], logger); err != nil {
+			logger.WithError(err).Fatal(ErrDBMigration)
+		}
+		return
+	}
+
 	apiURL = os.Getenv("API_URL")
 	if postgresConnectionString == "" || apiURL == "" {
 		logger.Fatal(ErrMissingEnvVars, ". POSTGRES_CONN_STRING and API_URL must be set.")
 	}
 
-	app := fiber.New()
+	retryPolicy, err := loadRetryPolicy()
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	sendRetryPolicy, err := loadSendRetryPolicy()
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	leaseDuration, err := envDuration("LEASE_DURATION", scheduler.DefaultLeaseDuration)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	if interval == 0 {
+		if interval, err = envDuration("DISPATCH_INTERVAL", scheduler.DefaultInterval); err != nil {
+			logger.WithError(err).Fatal(ErrInvalidEnvVar)
+		}
+	}
+
+	batchSize, err := envInt("BATCH_SIZE", scheduler.DefaultBatchSize)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	tickBudget, err := envDuration("TICK_BUDGET", scheduler.DefaultTickBudget)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	concurrency, err := envInt("CONCURRENCY", scheduler.DefaultConcurrency)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	notifyEnabled, err := envBool("NOTIFY_ENABLED", false)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	notifyDebounce, err := envDuration("NOTIFY_DEBOUNCE", scheduler.DefaultDebounce)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	leaderElection, err := envBool("LEADER_ELECTION", false)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	leaderCheckInterval, err := envDuration("LEADER_CHECK_INTERVAL", leader.DefaultCheckInterval)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	shutdownTimeout, err := envDuration("SHUTDOWN_TIMEOUT", defaultShutdownTimeout)
+	if err != nil {
+		logger.WithError(err).Fatal(ErrInvalidEnvVar)
+	}
+
+	app := fiber.New(fiber.Config{BodyLimit: bodyLimit})
 	app.Use(cors.New())
 
 	logger.Info("Starting the dispatch-go server...")
@@ -69,8 +160,8 @@ func main() {
 		logger.WithError(err).Fatal("Database connection failed")
 	}
 
-	if err := migrateDB(db, logger); err != nil {
-		logger.WithError(err).Fatal("Database migration failed")
+	if err := checkSchema(db, logger); err != nil {
+		logger.WithError(err).Fatal("Database schema check failed")
 	}
 
 	if fillData {
@@ -85,23 +176,105 @@ func main() {
 	}
 
 	msgRepo := repository.NewMessageRepository(db, logger)
-	msgDriver := driver.NewMessageDriver(apiURL, logger)
-	msgService := message.New(msgRepo, msgDriver, logger)
-	schedService := scheduler.New(msgService, logger)
+	msgDriver := driver.NewMessageDriver(apiURL, logger, driver.WithRetryPolicy(sendRetryPolicy))
+	msgService := message.New(msgRepo, msgDriver, logger, message.WithRetryPolicy(retryPolicy))
+	schedConfig := scheduler.Config{
+		InstanceID:    instanceID(),
+		Interval:      interval,
+		LeaseDuration: leaseDuration,
+		BatchSize:     batchSize,
+		TickBudget:    tickBudget,
+		Concurrency:   concurrency,
+		Debounce:      notifyDebounce,
+	}
+
+	var elector *leader.Elector
+	if leaderElection {
+		elector = leader.New(postgresConnectionString, schedConfig.InstanceID, leaderCheckInterval, logger)
+		schedConfig.Leader = elector.Leader
+		schedConfig.IsLeader = elector.IsLeader
+	}
+
+	schedService := scheduler.New(msgService, logger, schedConfig)
 	ctrl := controller.NewMessageController(msgService, schedService)
 
 	app.Get("/start", ctrl.Start)
 	app.Get("/stop", ctrl.Stop)
+	app.Get("/scheduler/status", ctrl.GetSchedulerStatus)
+	app.Post("/scheduler/run", ctrl.RunScheduler)
+	app.Put("/scheduler/config", ctrl.UpdateSchedulerConfig)
 	app.Get("/messages", ctrl.GetMessages)
+	app.Post("/messages", ctrl.CreateMessage)
+	app.Post("/messages/batch", ctrl.CreateMessages)
+	app.Get("/messages/:id", ctrl.GetMessage)
+	app.Delete("/messages/:id", ctrl.CancelMessage)
 
-	if err := schedService.Start(context.Background()); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if elector != nil {
+		// Every instance serves the API, but only the leader dispatches.
+		go elector.Run(ctx, func() {
+			if err := schedService.Start(ctx); err != nil {
+				logger.WithError(err).Error(ErrSchedulerStart)
+			}
+		}, func() {
+			stopCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := schedService.Stop(stopCtx); err != nil {
+				logger.WithError(err).Error("Failed to stop the scheduler")
+			}
+		})
+	} else if err := schedService.Start(ctx); err != nil {
 		logger.WithError(err).Fatal(ErrSchedulerStart)
 	}
 
-	logger.Info("Server is listening on :3000")
-	if err := app.Listen(":3000"); err != nil {
+	if notifyEnabled {
+		go listener.New(postgresConnectionString, logger).Run(ctx, schedService.Wake)
+	}
+
+	listenErr := make(chan error, 1)
+	go func() {
+		logger.Info("Server is listening on :3000")
+		listenErr <- app.Listen(":3000")
+	}()
+
+	select {
+	case err := <-listenErr:
 		logger.WithError(err).Fatal("Failed to start Fiber server")
+	case <-ctx.Done():
+		stop()
+		logger.Info("Shutdown signal received, shutting down...")
+	}
+
+	shutdown(app, schedService, db, shutdownTimeout, logger)
+}
+
+// shutdown stops the server within the timeout. The HTTP server goes first
+// so that the scheduler cannot be restarted through the API while it is
+// being stopped. Then the in-flight dispatch run is allowed to finish, and
+// finally the database pool is closed.
+func shutdown(app *fiber.App, sched scheduler.Scheduler, db *gorm.DB, timeout time.Duration, logger *logrus.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := app.ShutdownWithContext(ctx); err != nil {
+		logger.WithError(err).Error("Failed to shut down the HTTP server")
+	}
+
+	if err := sched.Stop(ctx); err != nil {
+		logger.WithError(err).Error("Failed to stop the scheduler")
+	}
+
+	sqlDB, err := db.DB()
+	if err == nil {
+		err = sqlDB.Close()
+	}
+	if err != nil {
+		logger.WithError(err).Error("Failed to close the database connection")
 	}
+
+	logger.Info("Shutdown complete")
 }
 
 func loadEnv(logger *logrus.Logger) error {
@@ -113,6 +286,105 @@ func loadEnv(logger *logrus.Logger) error {
 	return nil
 }
 
+// instanceID returns the INSTANCE_ID environment variable, or a unique ID
+// derived from the host name if it is not set.
+func instanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "dispatch-go"
+	}
+
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return hostname + "-" + hex.EncodeToString(suffix)
+}
+
+// loadRetryPolicy reads the retry policy overrides from the environment.
+func loadRetryPolicy() (message.RetryPolicy, error) {
+	policy := message.DefaultRetryPolicy()
+
+	var err error
+	if policy.MaxAttempts, err = envInt("MAX_ATTEMPTS", policy.MaxAttempts); err != nil {
+		return policy, err
+	}
+	if policy.BaseDelay, err = envDuration("RETRY_BASE_DELAY", policy.BaseDelay); err != nil {
+		return policy, err
+	}
+	if policy.MaxDelay, err = envDuration("RETRY_MAX_DELAY", policy.MaxDelay); err != nil {
+		return policy, err
+	}
+
+	return policy, nil
+}
+
+// loadSendRetryPolicy reads the overrides of the policy for retrying
+// transient provider errors within a single send from the environment.
+func loadSendRetryPolicy() (driver.RetryPolicy, error) {
+	policy := driver.DefaultRetryPolicy()
+
+	var err error
+	if policy.MaxAttempts, err = envInt("SEND_MAX_ATTEMPTS", policy.MaxAttempts); err != nil {
+		return policy, err
+	}
+	if policy.BaseDelay, err = envDuration("SEND_RETRY_BASE_DELAY", policy.BaseDelay); err != nil {
+		return policy, err
+	}
+	if policy.MaxDelay, err = envDuration("SEND_RETRY_MAX_DELAY", policy.MaxDelay); err != nil {
+		return policy, err
+	}
+
+	return policy, nil
+}
+
+// envInt returns the positive integer stored in the environment variable, or
+// def if it is not set.
+func envInt(key string, def int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", key, raw)
+	}
+	return n, nil
+}
+
+// envBool returns the boolean stored in the environment variable, or def if
+// it is not set.
+func envBool(key string, def bool) (bool, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", key, raw)
+	}
+	return b, nil
+}
+
+// envDuration returns the positive duration stored in the environment
+// variable, or def if it is not set.
+func envDuration(key string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration such as 30s or 5m, got %q", key, raw)
+	}
+	return d, nil
+}
+
 func connectDB(dsn string, logger *logrus.Logger) (*gorm.DB, error) {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -123,12 +395,83 @@ func connectDB(dsn string, logger *logrus.Logger) (*gorm.DB, error) {
 	return db, nil
 }
 
-func migrateDB(db *gorm.DB, logger *logrus.Logger) error {
-	if err := db.AutoMigrate(&model.Message{}); err != nil {
-		logger.WithError(err).Error("Database migration error")
-		return ErrDBMigration
+func newMigrator(db *gorm.DB, logger *logrus.Logger) (*migration.Migrator, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	return migration.New(sqlDB, logger)
+}
+
+// checkSchema refuses to start the server while migrations are pending. The
+// schema is only changed by the migrate subcommand.
+func checkSchema(db *gorm.DB, logger *logrus.Logger) error {
+	migrator, err := newMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d pending migration(s), run 'dispatch-go migrate up'", ErrSchemaOutdated, pending)
 	}
-	logger.Info("Database schema migrated successfully")
+
+	logger.Info("Database schema is up to date")
+	return nil
+}
+
+// runMigrate implements the 'migrate up|down|status' subcommand.
+func runMigrate(dsn string, args []string, logger *logrus.Logger) error {
+	if len(args) != 1 {
+		return ErrMigrateUsage
+	}
+
+	db, err := connectDB(dsn, logger)
+	if err != nil {
+		return err
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	migrator, err := newMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Applied %d migration(s)", applied)
+	case "down":
+		reverted, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Reverted migration %04d_%s", reverted.Version, reverted.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied at " + status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	default:
+		return ErrMigrateUsage
+	}
+
 	return nil
 }
 
@@ -1,8 +1,16 @@
 package controller
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 
+	"github.com/ecoderat/dispatch-go/internal/model"
+
 	"github.com/ecoderat/dispatch-go/internal/service/message"
 	"github.com/ecoderat/dispatch-go/internal/service/scheduler"
 )
@@ -11,12 +19,73 @@ type MessageController interface {
 	Start(c *fiber.Ctx) error
 	Stop(c *fiber.Ctx) error
 	GetMessages(c *fiber.Ctx) error
+	CreateMessage(c *fiber.Ctx) error
+	CreateMessages(c *fiber.Ctx) error
+	GetMessage(c *fiber.Ctx) error
+	CancelMessage(c *fiber.Ctx) error
+	UpdateSchedulerConfig(c *fiber.Ctx) error
+	GetSchedulerStatus(c *fiber.Ctx) error
+	RunScheduler(c *fiber.Ctx) error
 }
 
 type messageController struct {
 	services services
 }
 
+type createMessageResponse struct {
+	ID     int                 `json:"id"`
+	Status model.MessageStatus `json:"status"`
+}
+
+type listMessagesResponse struct {
+	Messages   []model.Message `json:"messages"`
+	NextCursor int             `json:"next_cursor,omitempty"`
+}
+
+type createMessagesRequest struct {
+	Messages []message.MessageRequest `json:"messages"`
+}
+
+type createMessagesResponse struct {
+	Accepted int                 `json:"accepted"`
+	Rejected int                 `json:"rejected"`
+	Results  []batchItemResponse `json:"results"`
+}
+
+type batchItemResponse struct {
+	Index    int                 `json:"index"`
+	Accepted bool                `json:"accepted"`
+	ID       int                 `json:"id,omitempty"`
+	Status   model.MessageStatus `json:"status,omitempty"`
+	Reason   string              `json:"reason,omitempty"`
+}
+
+type schedulerConfigRequest struct {
+	Interval    *string `json:"interval"`
+	BatchSize   *int    `json:"batch_size"`
+	Concurrency *int    `json:"concurrency"`
+}
+
+type schedulerConfigResponse struct {
+	Interval    string `json:"interval"`
+	BatchSize   int    `json:"batch_size"`
+	Concurrency int    `json:"concurrency"`
+}
+
+type schedulerStatusResponse struct {
+	InstanceID        string     `json:"instance_id"`
+	Leader            string     `json:"leader,omitempty"`
+	Running           bool       `json:"running"`
+	RunInProgress     bool       `json:"run_in_progress"`
+	LastRunStartedAt  *time.Time `json:"last_run_started_at,omitempty"`
+	LastRunFinishedAt *time.Time `json:"last_run_finished_at,omitempty"`
+	NextRunAt         *time.Time `json:"next_run_at,omitempty"`
+	Attempted         int        `json:"attempted"`
+	Sent              int        `json:"sent"`
+	Failed            int        `json:"failed"`
+	LastError         string     `json:"last_error,omitempty"`
+}
+
 type services struct {
 	scheduler scheduler.Scheduler
 	message   message.Service
@@ -34,6 +103,9 @@ func NewMessageController(msgService message.Service, schedService scheduler.Sch
 func (ctrl *messageController) Start(c *fiber.Ctx) error {
 	err := ctrl.services.scheduler.Start(c.Context())
 	if err != nil {
+		if errors.Is(err, scheduler.ErrNotLeader) {
+			return c.Status(fiber.StatusConflict).SendString("This instance is not the leader")
+		}
 		return c.Status(fiber.StatusInternalServerError).SendString("Unable to start the scheduler")
 	}
 
@@ -49,11 +121,267 @@ func (ctrl *messageController) Stop(c *fiber.Ctx) error {
 	return c.SendString("Server stopped")
 }
 
+func (ctrl *messageController) UpdateSchedulerConfig(c *fiber.Ctx) error {
+	var req schedulerConfigRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	update := scheduler.ConfigUpdate{
+		BatchSize:   req.BatchSize,
+		Concurrency: req.Concurrency,
+	}
+	if req.Interval != nil {
+		interval, err := time.ParseDuration(*req.Interval)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Interval must be a duration such as 30s or 5m")
+		}
+		update.Interval = &interval
+	}
+
+	config, err := ctrl.services.scheduler.UpdateConfig(update)
+	if err != nil {
+		switch {
+		case errors.Is(err, scheduler.ErrInvalidInterval):
+			return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Interval must be at least %s", scheduler.MinInterval))
+		case errors.Is(err, scheduler.ErrInvalidBatchSize):
+			return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Batch size must be between 1 and %d", scheduler.MaxBatchSize))
+		case errors.Is(err, scheduler.ErrInvalidConcurrency):
+			return c.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Concurrency must be between 1 and %d", scheduler.MaxConcurrency))
+		default:
+			return c.Status(fiber.StatusInternalServerError).SendString("Unable to update the scheduler configuration")
+		}
+	}
+
+	return c.JSON(schedulerConfigResponse{
+		Interval:    config.Interval.String(),
+		BatchSize:   config.BatchSize,
+		Concurrency: config.Concurrency,
+	})
+}
+
+func (ctrl *messageController) RunScheduler(c *fiber.Ctx) error {
+	err := ctrl.services.scheduler.RunNow()
+	if err != nil {
+		if errors.Is(err, scheduler.ErrRunInProgress) {
+			return c.Status(fiber.StatusConflict).SendString("A dispatch run is already in progress")
+		}
+		if errors.Is(err, scheduler.ErrNotLeader) {
+			return c.Status(fiber.StatusConflict).SendString("This instance is not the leader")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Unable to start a dispatch run")
+	}
+
+	return c.Status(fiber.StatusAccepted).SendString("Dispatch run started")
+}
+
+func (ctrl *messageController) GetSchedulerStatus(c *fiber.Ctx) error {
+	status := ctrl.services.scheduler.Status()
+
+	return c.JSON(schedulerStatusResponse{
+		InstanceID:        status.InstanceID,
+		Leader:            status.Leader,
+		Running:           status.Running,
+		RunInProgress:     status.RunInProgress,
+		LastRunStartedAt:  status.LastRunStartedAt,
+		LastRunFinishedAt: status.LastRunFinishedAt,
+		NextRunAt:         status.NextRunAt,
+		Attempted:         status.Attempted,
+		Sent:              status.Sent,
+		Failed:            status.Failed,
+		LastError:         status.LastError,
+	})
+}
+
 func (ctrl *messageController) GetMessages(c *fiber.Ctx) error {
-	messages, err := ctrl.services.message.GetSentMessages(c.Context())
+	filter, err := parseMessageFilter(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	page, err := ctrl.services.message.ListMessages(c.Context(), filter)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString("Unable to fetch messages")
+	}
+
+	if page.Messages == nil {
+		page.Messages = []model.Message{}
+	}
+
+	return c.JSON(listMessagesResponse{
+		Messages:   page.Messages,
+		NextCursor: page.NextCursor,
+	})
+}
+
+func (ctrl *messageController) GetMessage(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("Message ID must be a positive integer")
+	}
+
+	msg, err := ctrl.services.message.GetMessage(c.Context(), id)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).SendString("Unable to fetch sent messages")
+		if errors.Is(err, message.ErrMessageNotFound) {
+			return c.Status(fiber.StatusNotFound).SendString("Message not found")
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Unable to fetch message")
 	}
 
-	return c.JSON(messages)
+	return c.JSON(msg)
+}
+
+func (ctrl *messageController) CancelMessage(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("Message ID must be a positive integer")
+	}
+
+	err = ctrl.services.message.CancelMessage(c.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, message.ErrMessageNotFound):
+			return c.Status(fiber.StatusNotFound).SendString("Message not found")
+		case errors.Is(err, message.ErrNotCancellable):
+			return c.Status(fiber.StatusConflict).SendString("Message has already been dispatched")
+		default:
+			return c.Status(fiber.StatusInternalServerError).SendString("Unable to cancel message")
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// parseMessageFilter builds a message filter from the query string. The
+// returned error is meant to be shown to the client.
+func parseMessageFilter(c *fiber.Ctx) (model.MessageFilter, error) {
+	filter := model.MessageFilter{
+		Recipient: strings.TrimSpace(c.Query("recipient")),
+	}
+
+	// Without a status filter only sent messages are listed, as before
+	// filtering was introduced.
+	if raw := c.Query("status"); raw != "" {
+		for _, status := range strings.Split(raw, ",") {
+			status := model.MessageStatus(strings.TrimSpace(status))
+			if !status.Valid() {
+				return filter, fmt.Errorf("Unknown status %q", status)
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	} else {
+		filter.Statuses = []model.MessageStatus{model.StatusSent}
+	}
+
+	var err error
+	if filter.CreatedAfter, err = parseTimeQuery(c, "created_after"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedBefore, err = parseTimeQuery(c, "created_before"); err != nil {
+		return filter, err
+	}
+	if filter.AfterID, err = parsePositiveIntQuery(c, "cursor"); err != nil {
+		return filter, err
+	}
+	if filter.Limit, err = parsePositiveIntQuery(c, "limit"); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+func parseTimeQuery(c *fiber.Ctx, key string) (time.Time, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Query parameter %s must be an RFC 3339 timestamp", key)
+	}
+	return t, nil
+}
+
+func parsePositiveIntQuery(c *fiber.Ctx, key string) (int, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Query parameter %s must be a positive integer", key)
+	}
+	return n, nil
+}
+
+func (ctrl *messageController) CreateMessage(c *fiber.Ctx) error {
+	var req message.MessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	msg, err := ctrl.services.message.CreateMessage(c.Context(), req)
+	if err != nil {
+		if reason, ok := validationReason(err); ok {
+			return c.Status(fiber.StatusBadRequest).SendString(reason)
+		}
+		return c.Status(fiber.StatusInternalServerError).SendString("Unable to create message")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(createMessageResponse{
+		ID:     msg.ID,
+		Status: msg.Status,
+	})
+}
+
+func (ctrl *messageController) CreateMessages(c *fiber.Ctx) error {
+	var req createMessagesRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	results, err := ctrl.services.message.CreateMessages(c.Context(), req.Messages)
+	if err != nil {
+		switch {
+		case errors.Is(err, message.ErrEmptyBatch):
+			return c.Status(fiber.StatusBadRequest).SendString("Batch must contain at least one message")
+		case errors.Is(err, message.ErrBatchTooLarge):
+			return c.Status(fiber.StatusRequestEntityTooLarge).SendString(fmt.Sprintf("Batch must not exceed %d messages", message.MaxBatchSize))
+		default:
+			return c.Status(fiber.StatusInternalServerError).SendString("Unable to create messages")
+		}
+	}
+
+	resp := createMessagesResponse{Results: make([]batchItemResponse, len(results))}
+	for i, result := range results {
+		item := batchItemResponse{Index: result.Index}
+		if result.Err != nil {
+			item.Reason, _ = validationReason(result.Err)
+			resp.Rejected++
+		} else {
+			item.Accepted = true
+			item.ID = result.ID
+			item.Status = result.Status
+			resp.Accepted++
+		}
+		resp.Results[i] = item
+	}
+
+	return c.JSON(resp)
+}
+
+// validationReason maps message validation errors to a client-facing reason.
+func validationReason(err error) (string, bool) {
+	switch {
+	case errors.Is(err, message.ErrInvalidRecipient):
+		return "Recipient must be a phone number in E.164 format", true
+	case errors.Is(err, message.ErrEmptyContent):
+		return "Content must not be empty", true
+	case errors.Is(err, message.ErrContentTooLong):
+		return fmt.Sprintf("Content must not exceed %d characters", message.MaxContentLength), true
+	default:
+		return "", false
+	}
 }
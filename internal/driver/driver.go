@@ -7,13 +7,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/sirupsen/logrus"
-)
 
-const (
-	messageLengthLimit = 160
-	multipartThreshold = 153
+	"github.com/ecoderat/dispatch-go/internal/segment"
 )
 
 //go:generate mockery --name=MessageDriver --output=../../mock/driver --outpkg=mockdriver --case=underscore --with-expecter
@@ -22,19 +20,64 @@ type MessageDriver interface {
 }
 
 type messageDriver struct {
-	httpClient *http.Client
-	apiURL     string
-	logger     *logrus.Logger
+	httpClient  *http.Client
+	apiURL      string
+	retryPolicy RetryPolicy
+	logger      *logrus.Logger
 }
 
 type MessageRequest struct {
 	Recipient string `json:"to"`
 	Content   string `json:"content"`
+
+	// SkipParts lists the 1-based numbers of multipart parts that an earlier
+	// attempt already delivered. They are not sent again.
+	SkipParts []int `json:"-"`
+
+	// OnPart, if set, is called with every multipart part as soon as the
+	// provider accepts it, so that the part can be recorded before the next
+	// one is sent. An error stops the send.
+	OnPart func(ctx context.Context, part PartResponse) error `json:"-"`
 }
 
 type MessageResponse struct {
 	Message   string `json:"message"`
 	MessageID string `json:"messageId"`
+
+	// StatusCode is the HTTP status code returned by the provider.
+	StatusCode int `json:"-"`
+
+	// Parts lists the parts of a multipart message sent by this call. For a
+	// multipart message, Message, MessageID and StatusCode are those of the
+	// last part sent. Parts is empty for a single SMS.
+	Parts []PartResponse `json:"-"`
+}
+
+// PartResponse is a part of a multipart message accepted by the provider.
+type PartResponse struct {
+	// Number is the 1-based number of the part.
+	Number    int
+	MessageID string
+	// Content is the text sent for the part, including its counter.
+	Content  string
+	Encoding segment.Encoding
+}
+
+// PartialSendError is returned when sending a multipart message fails at
+// part Part, or when OnPart fails for it. Sent lists the parts the provider
+// accepted, so a retry can skip them.
+type PartialSendError struct {
+	Part int
+	Sent []PartResponse
+	Err  error
+}
+
+func (e *PartialSendError) Error() string {
+	return fmt.Sprintf("part %d: %v", e.Part, e.Err)
+}
+
+func (e *PartialSendError) Unwrap() error {
+	return e.Err
 }
 
 var (
@@ -46,42 +89,75 @@ var (
 	ErrUnmarshalResponse = fmt.Errorf("driver: failed to unmarshal response")
 )
 
-func NewMessageDriver(apiURL string, logger *logrus.Logger) MessageDriver {
-	return &messageDriver{
-		httpClient: &http.Client{},
-		apiURL:     apiURL,
-		logger:     logger,
+func NewMessageDriver(apiURL string, logger *logrus.Logger, opts ...Option) MessageDriver {
+	m := &messageDriver{
+		httpClient:  &http.Client{Timeout: DefaultTimeout},
+		apiURL:      apiURL,
+		retryPolicy: DefaultRetryPolicy(),
+		logger:      logger,
 	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
 }
 
 func (m *messageDriver) Send(ctx context.Context, req MessageRequest) (*MessageResponse, error) {
-	if len(req.Content) > messageLengthLimit {
-		m.logger.Info("Content length exceeds maximum for a single SMS, splitting into multipart SMS.")
-
-		var parts []string
-		for i := 0; i < len(req.Content); i += multipartThreshold {
-			end := i + multipartThreshold
-			// Make sure we don't exceed the length of req.Content
-			if end > len(req.Content) {
-				end = len(req.Content)
-			}
-			parts = append(parts, req.Content[i:end])
+	encoding, parts := splitContent(req.Content)
+	if len(parts) > 1 {
+		m.logger.WithFields(logrus.Fields{
+			"encoding": encoding,
+			"parts":    len(parts),
+		}).Info("Content length exceeds maximum for a single SMS, splitting into multipart SMS.")
+
+		skip := make(map[int]bool, len(req.SkipParts))
+		for _, number := range req.SkipParts {
+			skip[number] = true
 		}
 
 		var lastResp *MessageResponse
+		var sent []PartResponse
 		for partIndex, partContent := range parts {
+			number := partIndex + 1
+			if skip[number] {
+				m.logger.WithField("part", number).Info("Skipping part delivered by an earlier attempt")
+				continue
+			}
+
 			reqTemporary := MessageRequest{
 				Recipient: req.Recipient,
-				Content:   partContent + fmt.Sprintf(" [%d/%d]", partIndex+1, len(parts)),
+				Content:   partContent + partSuffix(number, len(parts)),
 			}
 
-			resp, err := m.sendPart(ctx, reqTemporary)
+			resp, err := m.sendPartWithRetry(ctx, reqTemporary)
 			if err != nil {
-				m.logger.WithError(err).Errorf("Failed to send part %d of multipart message", partIndex+1)
-				return nil, err
+				m.logger.WithError(err).Errorf("Failed to send part %d of multipart message", number)
+				return nil, &PartialSendError{Part: number, Sent: sent, Err: err}
 			}
+			part := PartResponse{
+				Number:    number,
+				MessageID: resp.MessageID,
+				Content:   reqTemporary.Content,
+				Encoding:  encoding,
+			}
+			sent = append(sent, part)
 			lastResp = resp
+
+			if req.OnPart != nil {
+				if err := req.OnPart(ctx, part); err != nil {
+					m.logger.WithError(err).Errorf("Failed to record part %d of multipart message", number)
+					return nil, &PartialSendError{Part: number, Sent: sent, Err: err}
+				}
+			}
+		}
+
+		if lastResp == nil {
+			// Every part was delivered by earlier attempts.
+			lastResp = &MessageResponse{}
 		}
+		lastResp.Parts = sent
 
 		m.logger.WithFields(logrus.Fields{
 			"recipient": req.Recipient,
@@ -91,7 +167,7 @@ func (m *messageDriver) Send(ctx context.Context, req MessageRequest) (*MessageR
 		return lastResp, nil
 	}
 
-	resp, err := m.sendPart(ctx, req)
+	resp, err := m.sendPartWithRetry(ctx, req)
 	if err != nil {
 		m.logger.WithError(err).Error("Failed to send message")
 		return nil, err
@@ -104,6 +180,32 @@ func (m *messageDriver) Send(ctx context.Context, req MessageRequest) (*MessageR
 	return resp, nil
 }
 
+// splitContent splits content into SMS parts, leaving room in every part for
+// the part counter appended by Send.
+func splitContent(content string) (segment.Encoding, []string) {
+	reserve := 0
+	for {
+		encoding, parts := segment.Split(content, reserve)
+		if len(parts) == 1 {
+			return encoding, parts
+		}
+
+		// The counter grows with the number of parts, so split again until
+		// the reserved room is large enough.
+		need := len(partSuffix(len(parts), len(parts)))
+		if need <= reserve {
+			return encoding, parts
+		}
+		reserve = need
+	}
+}
+
+// partSuffix is the counter appended to part i of n. It only uses GSM-7
+// basic characters, so its length is the same in both encodings.
+func partSuffix(i, n int) string {
+	return fmt.Sprintf(" [%d/%d]", i, n)
+}
+
 func (m *messageDriver) sendPart(ctx context.Context, req MessageRequest) (*MessageResponse, error) {
 	body, err := json.Marshal(req)
 	if err != nil {
@@ -122,13 +224,23 @@ func (m *messageDriver) sendPart(ctx context.Context, req MessageRequest) (*Mess
 	resp, err := m.httpClient.Do(httpReq)
 	if err != nil {
 		m.logger.WithError(err).Error(ErrSendHTTPRequest)
-		return nil, fmt.Errorf("%w: %v", ErrSendHTTPRequest, err)
+		return nil, fmt.Errorf("%w: %w", ErrSendHTTPRequest, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusAccepted {
-		m.logger.WithField("status_code", resp.StatusCode).Error(ErrUnexpectedStatus)
-		return nil, fmt.Errorf("%w: %d", ErrUnexpectedStatus, resp.StatusCode)
+	// The provider answers 202 Accepted, but any 2xx means it took the
+	// message, so it is not treated as a failure that would be resent.
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// The body is only kept for diagnostics, so a failed read leaves
+		// it empty.
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, MaxErrorBodyLength))
+		providerErr := newProviderError(resp, body, time.Now())
+		m.logger.WithFields(logrus.Fields{
+			"status_code": providerErr.StatusCode,
+			"reason":      providerErr.Reason,
+			"retryable":   providerErr.Retryable,
+		}).Error(ErrUnexpectedStatus)
+		return nil, providerErr
 	}
 
 	respBody, err := io.ReadAll(resp.Body)
@@ -142,6 +254,7 @@ func (m *messageDriver) sendPart(ctx context.Context, req MessageRequest) (*Mess
 		m.logger.WithError(err).Error(ErrUnmarshalResponse)
 		return nil, fmt.Errorf("%w: %v", ErrUnmarshalResponse, err)
 	}
+	messageResp.StatusCode = resp.StatusCode
 
 	m.logger.WithFields(logrus.Fields{
 		"recipient":  req.Recipient,
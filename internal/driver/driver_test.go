@@ -3,12 +3,19 @@ package driver
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/ecoderat/dispatch-go/internal/segment"
 )
 
 func TestMessageDriver_Send_Success(t *testing.T) {
@@ -30,6 +37,8 @@ func TestMessageDriver_Send_Success(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "ok", resp.Message)
 	assert.Equal(t, "123", resp.MessageID)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Empty(t, resp.Parts)
 }
 
 func TestMessageDriver_Send_UnmarshallError(t *testing.T) {
@@ -97,4 +106,323 @@ func TestMessageDriver_Send_MultipartMessage(t *testing.T) {
 	assert.Equal(t, "ok", resp.Message)
 	assert.Equal(t, "part", resp.MessageID)
 	assert.True(t, len(receivedParts) > 1, "Should send multiple parts")
+	assert.Len(t, resp.Parts, len(receivedParts))
+	for i, part := range resp.Parts {
+		assert.Equal(t, i+1, part.Number)
+		assert.Equal(t, "part", part.MessageID)
+		assert.Equal(t, receivedParts[i], part.Content)
+		assert.Equal(t, segment.GSM7, part.Encoding)
+	}
+}
+
+func TestMessageDriver_Send_UnicodeMultipartMessage(t *testing.T) {
+	longContent := strings.Repeat("Şifreniz hazır 👋 ", 8)
+	var receivedParts []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req MessageRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		receivedParts = append(receivedParts, req.Content)
+		resp := MessageResponse{Message: "ok", MessageID: "part"}
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	driver := &messageDriver{
+		httpClient: server.Client(),
+		apiURL:     server.URL,
+		logger:     logrus.New(),
+	}
+
+	_, err := driver.Send(context.Background(), MessageRequest{Recipient: "+123", Content: longContent})
+	assert.NoError(t, err)
+	assert.Greater(t, len(receivedParts), 1)
+	for i, part := range receivedParts {
+		assert.True(t, utf8.ValidString(part), "part %d must not cut a character in half", i+1)
+		assert.LessOrEqual(t, segment.Length(part, segment.UCS2), segment.UCS2PartLimit)
+		assert.True(t, strings.HasSuffix(part, fmt.Sprintf(" [%d/%d]", i+1, len(receivedParts))))
+	}
+}
+
+func TestMessageDriver_Send_MultipartPartialFailure(t *testing.T) {
+	longContent := strings.Repeat("a", 400)
+	var mu sync.Mutex
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 2 {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(MessageResponse{Message: "ok", MessageID: fmt.Sprintf("p-%d", calls)})
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	driver := &messageDriver{
+		httpClient: server.Client(),
+		apiURL:     server.URL,
+		logger:     logrus.New(),
+	}
+
+	resp, err := driver.Send(context.Background(), MessageRequest{Recipient: "+123", Content: longContent})
+	assert.Nil(t, resp)
+
+	var partial *PartialSendError
+	assert.ErrorAs(t, err, &partial)
+	assert.Equal(t, 2, partial.Part)
+	if assert.Len(t, partial.Sent, 1) {
+		assert.Equal(t, 1, partial.Sent[0].Number)
+		assert.Equal(t, "p-1", partial.Sent[0].MessageID)
+		assert.True(t, strings.HasSuffix(partial.Sent[0].Content, " [1/3]"))
+		assert.Equal(t, segment.GSM7, partial.Sent[0].Encoding)
+	}
+	assert.ErrorIs(t, err, ErrUnexpectedStatus)
+}
+
+func TestMessageDriver_Send_SkipsDeliveredParts(t *testing.T) {
+	longContent := strings.Repeat("a", 400)
+	var receivedParts []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		var req MessageRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		receivedParts = append(receivedParts, req.Content)
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(MessageResponse{Message: "ok", MessageID: "part"})
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	driver := &messageDriver{
+		httpClient: server.Client(),
+		apiURL:     server.URL,
+		logger:     logrus.New(),
+	}
+
+	_, err := driver.Send(context.Background(), MessageRequest{Recipient: "+123", Content: longContent, SkipParts: []int{1}})
+	assert.NoError(t, err)
+	assert.Len(t, receivedParts, 2)
+	assert.True(t, strings.HasSuffix(receivedParts[0], " [2/3]"))
+	assert.True(t, strings.HasSuffix(receivedParts[1], " [3/3]"))
+}
+
+func TestMessageDriver_Send_RecordsEachPart(t *testing.T) {
+	longContent := strings.Repeat("a", 400)
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(MessageResponse{Message: "ok", MessageID: fmt.Sprintf("p-%d", calls)})
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	driver := &messageDriver{
+		httpClient: server.Client(),
+		apiURL:     server.URL,
+		logger:     logrus.New(),
+	}
+
+	var recorded []PartResponse
+	onPart := func(ctx context.Context, part PartResponse) error {
+		recorded = append(recorded, part)
+		if part.Number == 2 {
+			return assert.AnError
+		}
+		return nil
+	}
+
+	_, err := driver.Send(context.Background(), MessageRequest{Recipient: "+123", Content: longContent, OnPart: onPart})
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, 2, calls, "no part is sent after recording one fails")
+
+	var partial *PartialSendError
+	if assert.ErrorAs(t, err, &partial) {
+		assert.Equal(t, 2, partial.Part)
+		assert.Equal(t, recorded, partial.Sent)
+	}
+	if assert.Len(t, recorded, 2) {
+		assert.Equal(t, "p-1", recorded[0].MessageID)
+		assert.Equal(t, "p-2", recorded[1].MessageID)
+	}
+}
+
+func newRetryingDriver(server *httptest.Server) *messageDriver {
+	return &messageDriver{
+		httpClient:  server.Client(),
+		apiURL:      server.URL,
+		retryPolicy: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		logger:      logrus.New(),
+	}
+}
+
+func TestMessageDriver_Send_RetriesTransientErrors(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			// Drop the connection without a response.
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(MessageResponse{Message: "ok", MessageID: "123"})
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	resp, err := newRetryingDriver(server).Send(context.Background(), MessageRequest{Recipient: "+123", Content: "hi"})
+	assert.NoError(t, err)
+	assert.Equal(t, "123", resp.MessageID)
+	assert.Equal(t, 3, calls)
+}
+
+func TestMessageDriver_Send_GivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := newRetryingDriver(server).Send(context.Background(), MessageRequest{Recipient: "+123", Content: "hi"})
+	assert.ErrorIs(t, err, ErrUnexpectedStatus)
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 3, calls)
+}
+
+func TestMessageDriver_Send_LongRetryAfterEndsCall(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := newRetryingDriver(server).Send(context.Background(), MessageRequest{Recipient: "+123", Content: "hi"})
+	assert.ErrorIs(t, err, ErrUnexpectedStatus)
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestMessageDriver_Send_ClientErrorIsPermanent(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	longContent := strings.Repeat("a", 400)
+	_, err := newRetryingDriver(server).Send(context.Background(), MessageRequest{Recipient: "+123", Content: longContent})
+	assert.ErrorIs(t, err, ErrUnexpectedStatus)
+	assert.True(t, IsPermanent(err))
+	assert.Equal(t, 1, calls)
+
+	var providerErr *ProviderError
+	if assert.ErrorAs(t, err, &providerErr) {
+		assert.Equal(t, http.StatusBadRequest, providerErr.StatusCode)
+		assert.Equal(t, ReasonInvalidRequest, providerErr.Reason)
+		assert.Equal(t, "Bad Request", providerErr.Body)
+		assert.False(t, providerErr.Retryable)
+	}
+}
+
+func TestMessageDriver_Send_ProviderErrorCapsBody(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(strings.Repeat("ğ", MaxErrorBodyLength)))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := newRetryingDriver(server).Send(context.Background(), MessageRequest{Recipient: "+123", Content: "hi"})
+
+	var providerErr *ProviderError
+	if assert.ErrorAs(t, err, &providerErr) {
+		assert.Equal(t, strings.Repeat("ğ", MaxErrorBodyLength/2), providerErr.Body)
+		assert.True(t, utf8.ValidString(providerErr.Body))
+		assert.Contains(t, err.Error(), "422 (invalid_request)")
+	}
+}
+
+func TestNewProviderError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		reason     Reason
+		retryable  bool
+		permanent  bool
+	}{
+		{http.StatusBadRequest, ReasonInvalidRequest, false, true},
+		{http.StatusUnauthorized, ReasonUnauthorized, false, false},
+		{http.StatusNotFound, ReasonNotFound, false, false},
+		{http.StatusForbidden, ReasonUnauthorized, false, false},
+		{http.StatusConflict, ReasonUnexpectedStatus, false, false},
+		{http.StatusUnprocessableEntity, ReasonInvalidRequest, false, true},
+		{http.StatusRequestTimeout, ReasonTimeout, true, false},
+		{http.StatusTooManyRequests, ReasonRateLimited, true, false},
+		{http.StatusBadGateway, ReasonServerError, true, false},
+		{http.StatusGatewayTimeout, ReasonTimeout, true, false},
+		{http.StatusMultipleChoices, ReasonUnexpectedStatus, false, false},
+		{http.StatusFound, ReasonUnexpectedStatus, false, false},
+	}
+
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.statusCode, Header: http.Header{}}
+		err := newProviderError(resp, []byte("  oops\n"), time.Now())
+		assert.Equal(t, tt.reason, err.Reason, "status %d", tt.statusCode)
+		assert.Equal(t, tt.retryable, err.Retryable, "status %d", tt.statusCode)
+		assert.Equal(t, tt.permanent, IsPermanent(err), "status %d", tt.statusCode)
+		assert.Equal(t, "oops", err.Body)
+	}
+}
+
+func TestMessageDriver_Send_OtherSuccessStatusIsAccepted(t *testing.T) {
+	calls := 0
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(MessageResponse{Message: "ok", MessageID: "123"})
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	resp, err := newRetryingDriver(server).Send(context.Background(), MessageRequest{Recipient: "+123", Content: "hi"})
+	assert.NoError(t, err)
+	assert.Equal(t, "123", resp.MessageID)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		delay := policy.Backoff(attempt)
+		assert.GreaterOrEqual(t, delay, want/2, "attempt %d", attempt)
+		assert.LessOrEqual(t, delay, want, "attempt %d", attempt)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 2*time.Second, parseRetryAfter("2", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Zero(t, parseRetryAfter("soon", now))
+	assert.Zero(t, parseRetryAfter("", now))
 }
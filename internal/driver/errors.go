@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxErrorBodyLength is the number of bytes of a provider response body kept
+// in a ProviderError.
+const MaxErrorBodyLength = 512
+
+// Reason is a normalized cause of a provider error.
+type Reason string
+
+const (
+	ReasonInvalidRequest   Reason = "invalid_request"
+	ReasonUnauthorized     Reason = "unauthorized"
+	ReasonNotFound         Reason = "not_found"
+	ReasonTimeout          Reason = "timeout"
+	ReasonRateLimited      Reason = "rate_limited"
+	ReasonServerError      Reason = "server_error"
+	ReasonUnexpectedStatus Reason = "unexpected_status"
+)
+
+// ProviderError is returned when the provider responds with a status outside
+// 2xx. It wraps ErrUnexpectedStatus.
+type ProviderError struct {
+	StatusCode int
+	// Body is the start of the response body, at most MaxErrorBodyLength
+	// bytes.
+	Body   string
+	Reason Reason
+	// Retryable reports whether the request failed transiently, that is
+	// with a timeout, a rate limit or a server error, and is resent right
+	// away. Other statuses are not retried within the call and are left to
+	// the scheduler; see IsPermanent.
+	Retryable bool
+	// RetryAfter is the wait requested by the provider, or 0.
+	RetryAfter time.Duration
+}
+
+func newProviderError(resp *http.Response, body []byte, now time.Time) *ProviderError {
+	code := resp.StatusCode
+	return &ProviderError{
+		StatusCode: code,
+		Body:       snippet(body),
+		Reason:     reasonFor(code),
+		Retryable:  code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now),
+	}
+}
+
+func (e *ProviderError) Error() string {
+	msg := fmt.Sprintf("%v: %d (%s)", ErrUnexpectedStatus, e.StatusCode, e.Reason)
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *ProviderError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+func reasonFor(code int) Reason {
+	switch {
+	case code == http.StatusBadRequest || code == http.StatusUnprocessableEntity:
+		return ReasonInvalidRequest
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return ReasonUnauthorized
+	case code == http.StatusNotFound:
+		return ReasonNotFound
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return ReasonTimeout
+	case code == http.StatusTooManyRequests:
+		return ReasonRateLimited
+	case code >= 500:
+		return ReasonServerError
+	default:
+		return ReasonUnexpectedStatus
+	}
+}
+
+// snippet returns body cut to MaxErrorBodyLength bytes, with invalid UTF-8,
+// including a character cut in half by the limit, and surrounding whitespace
+// removed.
+func snippet(body []byte) string {
+	if len(body) > MaxErrorBodyLength {
+		body = body[:MaxErrorBodyLength]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(string(body), ""))
+}
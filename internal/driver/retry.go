@@ -0,0 +1,155 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultTimeout bounds a single request to the provider.
+const DefaultTimeout = 10 * time.Second
+
+// RetryPolicy controls how a request that failed with a transient error is
+// retried within a single Send call.
+type RetryPolicy struct {
+	// MaxAttempts is the number of requests made for a part, including the
+	// first one.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles with every
+	// further attempt and is jittered.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between two requests. A Retry-After longer than
+	// MaxDelay ends the call, leaving the retry to the scheduler, which waits
+	// at least as long as ProviderError.RetryAfter.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+	}
+}
+
+// Backoff returns the wait before the request following the given attempt.
+// The wait is chosen at random between half and all of the exponential
+// delay, so that clients failing together do not retry together.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
+
+// Option configures optional behaviour of the message driver.
+type Option func(*messageDriver)
+
+// WithRetryPolicy overrides the default retry policy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(m *messageDriver) {
+		m.retryPolicy = policy
+	}
+}
+
+// IsPermanent reports whether err is a failure that sending the message
+// again will not fix: a request that could not be encoded or that the
+// provider rejected as invalid. Other client errors, such as an expired API
+// key or a wrong URL, affect every message and are left to the scheduler's
+// backoff so that they can be fixed without losing the backlog.
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrMarshalRequest) {
+		return true
+	}
+
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Reason == ReasonInvalidRequest
+	}
+	return false
+}
+
+// isTransient reports whether err is worth retrying right away: a timeout,
+// a dropped connection or a retryable provider error such as a rate limit or
+// a server error.
+func isTransient(err error) bool {
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) {
+		return providerErr.Retryable
+	}
+
+	if !errors.Is(err, ErrSendHTTPRequest) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// parseRetryAfter returns the wait requested by a Retry-After header, given
+// either in seconds or as an HTTP date, or 0.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0)
+	}
+	return 0
+}
+
+// sendPartWithRetry sends a single SMS, retrying transient failures
+// according to the retry policy.
+func (m *messageDriver) sendPartWithRetry(ctx context.Context, req MessageRequest) (*MessageResponse, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := m.sendPart(ctx, req)
+		if err == nil || attempt >= m.retryPolicy.MaxAttempts || ctx.Err() != nil || !isTransient(err) {
+			return resp, err
+		}
+
+		delay := m.retryPolicy.Backoff(attempt)
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+			if providerErr.RetryAfter > m.retryPolicy.MaxDelay {
+				return nil, err
+			}
+			delay = providerErr.RetryAfter
+		}
+
+		m.logger.WithError(err).WithFields(logrus.Fields{
+			"attempt": attempt,
+			"delay":   delay,
+		}).Warn("Transient failure sending message part, retrying")
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+	}
+}
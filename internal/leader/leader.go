@@ -0,0 +1,161 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// lockKey is the Postgres advisory lock held by the leader. It is released
+// automatically when the leader's session ends, for example because the
+// process died.
+const lockKey = 4857282
+
+// leaderQuery looks up the application name, which is set to the instance
+// ID, of the session holding the lock. Single-key advisory locks are listed
+// in pg_locks with the key in objid and objsubid 1.
+const leaderQuery = `SELECT a.application_name FROM pg_locks l
+JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.classid = 0 AND l.objid = $1 AND l.objsubid = 1 AND l.granted`
+
+// DefaultCheckInterval is used when the check interval is not set.
+const DefaultCheckInterval = 5 * time.Second
+
+var (
+	ErrConnect = errors.New("leader: failed to connect")
+	ErrAcquire = errors.New("leader: failed to acquire leadership")
+	ErrLookup  = errors.New("leader: failed to look up the leader")
+)
+
+// Elector campaigns for leadership among the instances sharing a database.
+// At most one instance is leader at a time; when it goes away, another one
+// takes over within the check interval.
+type Elector struct {
+	dsn        string
+	instanceID string
+	interval   time.Duration
+	logger     *logrus.Logger
+
+	mu       sync.Mutex
+	leader   string
+	isLeader bool
+}
+
+func New(dsn, instanceID string, interval time.Duration, logger *logrus.Logger) *Elector {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	return &Elector{
+		dsn:        dsn,
+		instanceID: instanceID,
+		interval:   interval,
+		logger:     logger,
+	}
+}
+
+// Leader returns the instance ID of the current leader as of the last
+// check, or an empty string if it is unknown.
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.leader
+}
+
+// IsLeader reports whether this instance is the leader.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.isLeader
+}
+
+// Run campaigns for leadership until ctx is done. onElected is called when
+// this instance becomes leader, and onDemoted when it stops being leader,
+// including when ctx is done. Leadership is held until onDemoted returns.
+func (e *Elector) Run(ctx context.Context, onElected, onDemoted func()) {
+	for {
+		err := e.campaign(ctx, onElected, onDemoted)
+		if ctx.Err() != nil {
+			return
+		}
+
+		e.logger.WithError(err).WithField("retry_in", e.interval).Warn("Leader election connection lost")
+
+		select {
+		case <-time.After(e.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// campaign tries to take the lock on every check until the connection fails
+// or ctx is done. The lock is held for as long as the connection is open.
+func (e *Elector) campaign(ctx context.Context, onElected, onDemoted func()) error {
+	config, err := pgx.ParseConfig(e.dsn)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConnect, err)
+	}
+	config.RuntimeParams["application_name"] = e.instanceID
+
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConnect, err)
+	}
+	defer conn.Close(context.Background())
+
+	elected := false
+	defer func() {
+		e.set(false, "")
+		if elected {
+			e.logger.WithField("instance_id", e.instanceID).Info("Stepping down as leader")
+			onDemoted()
+		}
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		if !elected {
+			if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&elected); err != nil {
+				return fmt.Errorf("%w: %v", ErrAcquire, err)
+			}
+			if elected {
+				e.logger.WithField("instance_id", e.instanceID).Info("Elected leader")
+				e.set(true, e.instanceID)
+				onElected()
+			}
+		}
+
+		// The lookup doubles as a health check of the connection that holds
+		// the lock.
+		var leader string
+		err := conn.QueryRow(ctx, leaderQuery, lockKey).Scan(&leader)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: %v", ErrLookup, err)
+		}
+		e.set(elected, leader)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *Elector) set(isLeader bool, leader string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.isLeader = isLeader
+	e.leader = leader
+}
@@ -0,0 +1,81 @@
+package listener
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/sirupsen/logrus"
+)
+
+// Channel is the notification channel the message table trigger notifies
+// whenever messages are inserted.
+const Channel = "message_queued"
+
+// reconnectDelay is how long Run waits before reconnecting after the
+// connection was lost.
+const reconnectDelay = 5 * time.Second
+
+var (
+	ErrConnect = errors.New("listener: failed to connect")
+	ErrListen  = errors.New("listener: failed to listen")
+	ErrReceive = errors.New("listener: failed to receive notification")
+)
+
+// Listener receives Postgres notifications on Channel over a dedicated
+// connection.
+type Listener struct {
+	dsn    string
+	logger *logrus.Logger
+}
+
+func New(dsn string, logger *logrus.Logger) *Listener {
+	return &Listener{
+		dsn:    dsn,
+		logger: logger,
+	}
+}
+
+// Run calls onNotify for every notification until ctx is done. A lost
+// connection is re-established after a delay. Notifications sent while
+// disconnected are lost, so onNotify is also called after every connect.
+func (l *Listener) Run(ctx context.Context, onNotify func()) {
+	for {
+		err := l.listen(ctx, onNotify)
+		if ctx.Err() != nil {
+			return
+		}
+
+		l.logger.WithError(err).WithField("retry_in", reconnectDelay).Warn("Notification listener disconnected")
+
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *Listener) listen(ctx context.Context, onNotify func()) error {
+	conn, err := pgx.Connect(ctx, l.dsn)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConnect, err)
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{Channel}.Sanitize()); err != nil {
+		return fmt.Errorf("%w: %v", ErrListen, err)
+	}
+
+	l.logger.WithField("channel", Channel).Info("Listening for queued messages")
+	onNotify()
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return fmt.Errorf("%w: %v", ErrReceive, err)
+		}
+		onNotify()
+	}
+}
@@ -0,0 +1,323 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockKey is the Postgres advisory lock held while migrations run, so that
+// instances starting at the same time do not migrate concurrently.
+const lockKey = 4857281
+
+const createSchemaTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// schemaTableExists reports whether the schema table exists, without
+// requiring any privilege on it.
+const schemaTableExists = `SELECT to_regclass('schema_migrations') IS NOT NULL`
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var fileNamePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	ErrLoadMigrations  = errors.New("migration: failed to load migrations")
+	ErrAcquireLock     = errors.New("migration: failed to acquire migration lock")
+	ErrReadVersions    = errors.New("migration: failed to read applied versions")
+	ErrApplyMigration  = errors.New("migration: failed to apply migration")
+	ErrRevertMigration = errors.New("migration: failed to revert migration")
+	ErrNothingToRevert = errors.New("migration: no applied migration to revert")
+)
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies and reverts the versioned schema migrations and tracks them
+// in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	logger     *logrus.Logger
+}
+
+// New returns a migrator for the migrations embedded in this package.
+func New(db *sql.DB, logger *logrus.Logger) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLoadMigrations, err)
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		logger:     logger,
+	}, nil
+}
+
+// load reads the up and down scripts from fsys and returns them ordered by
+// version. Every version needs both scripts.
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected file name %q", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(fsys, "sql/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("version %d has conflicting names %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("version %d needs both an up and a down script", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Up applies all pending migrations in order and returns how many were
+// applied.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := versions[migration.Version]; ok {
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("%w %04d_%s: %v", ErrApplyMigration, migration.Version, migration.Name, err)
+			}
+
+			m.logger.WithFields(logrus.Fields{"version": migration.Version, "name": migration.Name}).Info("Applied migration")
+			applied++
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the most recently applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var reverted *Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, ok := versions[migration.Version]; !ok {
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("%w %04d_%s: %v", ErrRevertMigration, migration.Version, migration.Name, err)
+			}
+
+			m.logger.WithFields(logrus.Fields{"version": migration.Version, "name": migration.Name}).Info("Reverted migration")
+			reverted = &migration
+			return nil
+		}
+
+		return ErrNothingToRevert
+	})
+
+	return reverted, err
+}
+
+// Status returns every known migration and when it was applied. It only
+// reads the schema table, without taking the migration lock, so that it can
+// run under a role that may not change the schema. If the table does not
+// exist yet, every migration is pending.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var exists bool
+	if err := m.db.QueryRowContext(ctx, schemaTableExists).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReadVersions, err)
+	}
+
+	versions := map[int]time.Time{}
+	if exists {
+		var err error
+		if versions, err = appliedVersions(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration}
+		if appliedAt, ok := versions[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// Pending returns the number of migrations that have not been applied yet.
+func (m *Migrator) Pending(ctx context.Context) (int, error) {
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending++
+		}
+	}
+
+	return pending, nil
+}
+
+// withLock runs fn on a dedicated connection while holding the migration
+// advisory lock. The schema table is created if it does not exist yet. Only
+// Up and Down use it, since both change the schema.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAcquireLock, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("%w: %v", ErrAcquireLock, err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey); err != nil {
+			m.logger.WithError(err).Warn("Failed to release migration lock")
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createSchemaTable); err != nil {
+		return fmt.Errorf("%w: %v", ErrReadVersions, err)
+	}
+
+	return fn(conn)
+}
+
+// queryer is implemented by both *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// appliedVersions returns the applied migration versions and when they were
+// applied.
+func appliedVersions(ctx context.Context, db queryer) (map[int]time.Time, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReadVersions, err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrReadVersions, err)
+		}
+		versions[version] = appliedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrReadVersions, err)
+	}
+
+	return versions, nil
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
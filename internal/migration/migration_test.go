@@ -0,0 +1,178 @@
+package migration
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTestMigrator(t *testing.T, migrations []Migration) (*Migrator, sqlmock.Sqlmock, func()) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	assert.NoError(t, err)
+	migrator := &Migrator{db: db, migrations: migrations, logger: logrus.New()}
+	cleanup := func() { db.Close() }
+	return migrator, mock, cleanup
+}
+
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_lock($1)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(createSchemaTable).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_unlock($1)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0002_second.up.sql":   {Data: []byte("up 2")},
+		"sql/0002_second.down.sql": {Data: []byte("down 2")},
+		"sql/0001_first.up.sql":    {Data: []byte("up 1")},
+		"sql/0001_first.down.sql":  {Data: []byte("down 1")},
+	}
+
+	migrations, err := load(fsys)
+	assert.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "first", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "second", Up: "up 2", Down: "down 2"},
+	}, migrations)
+}
+
+func TestLoad_MissingDown(t *testing.T) {
+	fsys := fstest.MapFS{
+		"sql/0001_first.up.sql": {Data: []byte("up 1")},
+	}
+
+	migrations, err := load(fsys)
+	assert.Error(t, err)
+	assert.Nil(t, migrations)
+}
+
+func TestLoad_Embedded(t *testing.T) {
+	migrations, err := load(files)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, i+1, migration.Version, "migration versions must be consecutive")
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	migrator, mock, cleanup := setupTestMigrator(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+	})
+	defer cleanup()
+
+	expectLock(mock)
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE b ()`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`).WithArgs(2, "second").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	applied, err := migrator.Up(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Up_Fails(t *testing.T) {
+	migrator, mock, cleanup := setupTestMigrator(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+	})
+	defer cleanup()
+
+	expectLock(mock)
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE a ()`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	applied, err := migrator.Up(context.Background())
+	assert.ErrorIs(t, err, ErrApplyMigration)
+	assert.Zero(t, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Down(t *testing.T) {
+	migrator, mock, cleanup := setupTestMigrator(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+	})
+	defer cleanup()
+
+	expectLock(mock)
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()).AddRow(2, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DROP TABLE b`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations WHERE version = $1`).WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	reverted, err := migrator.Down(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reverted.Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Down_NothingApplied(t *testing.T) {
+	migrator, mock, cleanup := setupTestMigrator(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+	})
+	defer cleanup()
+
+	expectLock(mock)
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}))
+	expectUnlock(mock)
+
+	reverted, err := migrator.Down(context.Background())
+	assert.ErrorIs(t, err, ErrNothingToRevert)
+	assert.Nil(t, reverted)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Pending(t *testing.T) {
+	migrator, mock, cleanup := setupTestMigrator(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+	})
+	defer cleanup()
+
+	// Checking for pending migrations must neither lock nor change the schema.
+	mock.ExpectQuery(schemaTableExists).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at"}).AddRow(1, time.Now()))
+
+	pending, err := migrator.Pending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pending)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMigrator_Pending_NoSchemaTable(t *testing.T) {
+	migrator, mock, cleanup := setupTestMigrator(t, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a ()", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b ()", Down: "DROP TABLE b"},
+	})
+	defer cleanup()
+
+	mock.ExpectQuery(schemaTableExists).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	pending, err := migrator.Pending(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pending)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,7 @@
+-- The baseline adopts message tables created by gorm's AutoMigrate, so
+-- reverting it would drop data this migration never created. It is refused;
+-- drop message_attempt and message by hand if that is really intended.
+DO $$
+BEGIN
+    RAISE EXCEPTION 'refusing to revert the baseline migration: it would drop the message and message_attempt tables';
+END $$;
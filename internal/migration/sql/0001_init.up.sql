@@ -0,0 +1,45 @@
+-- Baseline schema. Written with IF NOT EXISTS so that databases previously
+-- created by gorm's AutoMigrate are adopted without changes.
+CREATE TABLE IF NOT EXISTS message (
+    id BIGSERIAL PRIMARY KEY,
+    recipient TEXT,
+    content TEXT,
+    status TEXT,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+
+ALTER TABLE message ADD COLUMN IF NOT EXISTS provider_message_id TEXT;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS attempts BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS last_error TEXT;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS sent_at TIMESTAMPTZ;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS lease_owner TEXT;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_message_deleted_at ON message (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_message_recipient ON message (recipient);
+CREATE INDEX IF NOT EXISTS idx_message_created_at ON message (created_at);
+
+CREATE TABLE IF NOT EXISTS message_attempt (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT,
+    attempt BIGINT,
+    part_index BIGINT,
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    http_status BIGINT,
+    provider_message_id TEXT,
+    error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_attempt_message_id ON message_attempt (message_id);
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_message_history') THEN
+        ALTER TABLE message_attempt
+            ADD CONSTRAINT fk_message_history FOREIGN KEY (message_id) REFERENCES message (id);
+    END IF;
+END $$;
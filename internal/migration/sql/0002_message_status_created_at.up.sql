@@ -0,0 +1,2 @@
+-- Supports the dispatch loop, which claims due messages oldest first.
+CREATE INDEX IF NOT EXISTS idx_message_status_created_at ON message (status, created_at) WHERE deleted_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS message_queued ON message;
+DROP FUNCTION IF EXISTS notify_message_queued();
@@ -0,0 +1,14 @@
+-- Wakes up dispatchers listening on the message_queued channel. The trigger
+-- fires once per statement so that batch inserts send a single notification.
+CREATE OR REPLACE FUNCTION notify_message_queued() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('message_queued', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS message_queued ON message;
+CREATE TRIGGER message_queued
+    AFTER INSERT ON message
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_message_queued();
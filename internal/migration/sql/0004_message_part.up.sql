@@ -0,0 +1,9 @@
+CREATE TABLE message_part (
+    id BIGSERIAL PRIMARY KEY,
+    message_id BIGINT NOT NULL REFERENCES message (id),
+    part_index BIGINT NOT NULL,
+    provider_message_id TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_message_part_message_id_part_index ON message_part (message_id, part_index);
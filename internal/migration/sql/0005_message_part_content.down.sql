@@ -0,0 +1,3 @@
+ALTER TABLE message_part
+    DROP COLUMN encoding,
+    DROP COLUMN content;
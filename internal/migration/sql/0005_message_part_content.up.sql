@@ -0,0 +1,3 @@
+ALTER TABLE message_part
+    ADD COLUMN content TEXT NOT NULL DEFAULT '',
+    ADD COLUMN encoding TEXT NOT NULL DEFAULT '';
@@ -12,17 +12,48 @@ const (
 	StatusSent    MessageStatus = "sent"
 	StatusFailed  MessageStatus = "failed"
 	StatusPending MessageStatus = "pending"
+	// StatusProcessing marks a message claimed by a dispatcher instance that
+	// is currently sending it.
+	StatusProcessing MessageStatus = "processing"
+	// StatusDead is terminal: the message ran out of attempts and is no
+	// longer retried.
+	StatusDead MessageStatus = "dead"
 )
 
+// Valid reports whether s is one of the known message statuses.
+func (s MessageStatus) Valid() bool {
+	switch s {
+	case StatusSent, StatusFailed, StatusPending, StatusProcessing, StatusDead:
+		return true
+	default:
+		return false
+	}
+}
+
 type Message struct {
 	ID        int           `json:"id"`
-	Recipient string        `json:"recipient"`
+	Recipient string        `json:"recipient" gorm:"index"`
 	Content   string        `json:"content"`
 	Status    MessageStatus `json:"status"`
 
-	CreatedAt time.Time      `gorm:"column:created_at"`
-	UpdatedAt time.Time      `gorm:"column:updated_at"`
-	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at"`
+	ProviderMessageID string     `json:"provider_message_id"`
+	Attempts          int        `json:"attempts" gorm:"not null;default:0"`
+	LastError         string     `json:"last_error"`
+	SentAt            *time.Time `json:"sent_at"`
+	NextAttemptAt     *time.Time `json:"next_attempt_at"`
+	LeaseOwner        string     `json:"lease_owner"`
+	LeaseExpiresAt    *time.Time `json:"lease_expires_at"`
+
+	// History is only loaded when looking up a single message.
+	History []MessageAttempt `json:"history,omitempty" gorm:"foreignKey:MessageID"`
+	// Parts lists the parts of a multipart message the provider has
+	// accepted. It is loaded when looking up a single message and when
+	// claiming messages for dispatch.
+	Parts []MessagePart `json:"parts,omitempty" gorm:"foreignKey:MessageID"`
+
+	CreatedAt time.Time      `json:"created_at" gorm:"column:created_at;index"`
+	UpdatedAt time.Time      `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"column:deleted_at"`
 }
 
 // GORM uses plural table names, so we need to override the table name
@@ -30,3 +61,73 @@ type Message struct {
 func (Message) TableName() string {
 	return "message"
 }
+
+// MessageFilter narrows down and pages a message listing. Zero values leave
+// the corresponding condition out. Results are ordered by ID, and AfterID is
+// the cursor returned by the previous page.
+type MessageFilter struct {
+	Statuses      []MessageStatus
+	Recipient     string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	AfterID       int
+	Limit         int
+}
+
+// Delivery is the outcome of a single attempt to send a message.
+type Delivery struct {
+	// LeaseOwner is the instance that claimed the message for this attempt.
+	LeaseOwner string
+
+	Status            MessageStatus
+	ProviderMessageID string
+	HTTPStatus        int
+	Error             string
+	StartedAt         time.Time
+	FinishedAt        time.Time
+	// PartIndex is the 1-based number of the part that failed, or 0.
+	PartIndex int
+
+	// NextAttemptAt is when a failed message becomes due for a retry. It is
+	// nil for sent and dead messages.
+	NextAttemptAt *time.Time
+}
+
+// MessageAttempt is a single entry in the delivery history of a message.
+// PartIndex is the 1-based part number of a multipart message, or 0 when the
+// attempt covers the whole message. HTTPStatus is 0 when the provider did not
+// respond.
+type MessageAttempt struct {
+	ID                int       `json:"id"`
+	MessageID         int       `json:"message_id" gorm:"index"`
+	Attempt           int       `json:"attempt"`
+	PartIndex         int       `json:"part_index"`
+	StartedAt         time.Time `json:"started_at"`
+	FinishedAt        time.Time `json:"finished_at"`
+	HTTPStatus        int       `json:"http_status"`
+	ProviderMessageID string    `json:"provider_message_id"`
+	Error             string    `json:"error"`
+}
+
+func (MessageAttempt) TableName() string {
+	return "message_attempt"
+}
+
+// MessagePart is a part of a multipart message that the provider accepted.
+// Parts are recorded as they are sent, so that a retry after a failure only
+// sends the remaining parts and every part's provider ID can be matched
+// against delivery receipts. PartIndex is the 1-based part number and
+// Content is the text sent for the part, including its counter.
+type MessagePart struct {
+	ID                int       `json:"id"`
+	MessageID         int       `json:"message_id" gorm:"uniqueIndex:idx_message_part_message_id_part_index"`
+	PartIndex         int       `json:"part_index" gorm:"uniqueIndex:idx_message_part_message_id_part_index"`
+	ProviderMessageID string    `json:"provider_message_id"`
+	Content           string    `json:"content"`
+	Encoding          string    `json:"encoding"`
+	SentAt            time.Time `json:"sent_at"`
+}
+
+func (MessagePart) TableName() string {
+	return "message_part"
+}
@@ -2,21 +2,50 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/ecoderat/dispatch-go/internal/model"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	ErrMessageNotFound       = errors.New("repository: message not found")
+	ErrMessageNotCancellable = errors.New("repository: message is no longer cancellable")
+	ErrLeaseLost             = errors.New("repository: message is no longer leased by this owner")
+)
+
+// UnknownOutcome is recorded as the error of attempts whose lease expired
+// before their result was stored, typically because the process died.
+const UnknownOutcome = "unknown outcome: lease expired before the result was recorded"
+
+// cancellableStatuses are the statuses of messages that are still waiting to
+// be dispatched, either for the first time or for a retry.
+var cancellableStatuses = []model.MessageStatus{model.StatusPending, model.StatusFailed}
+
 //go:generate mockery --name=MessageRepository --output=../../mock/repository --outpkg=mockrepository --case=underscore --with-expecter
 type MessageRepository interface {
-	Create(ctx context.Context, message model.Message) error
-	Update(ctx context.Context, id int, status model.MessageStatus) error
+	Create(ctx context.Context, message *model.Message) error
+	CreateMany(ctx context.Context, messages []model.Message) error
 	Delete(ctx context.Context, id int) error
-	GetAll(ctx context.Context, status ...model.MessageStatus) ([]model.Message, error)
+	List(ctx context.Context, filter model.MessageFilter) ([]model.Message, error)
+	GetByID(ctx context.Context, id int) (*model.Message, error)
+	Cancel(ctx context.Context, id int) error
+	RecordDelivery(ctx context.Context, id int, delivery model.Delivery) error
+	RecordPart(ctx context.Context, id int, owner string, part model.MessagePart, expiresAt time.Time) error
+	Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.Message, error)
+	ReleaseExpired(ctx context.Context, now time.Time, maxAttempts int) (int, error)
+	RenewLease(ctx context.Context, id int, owner string, expiresAt time.Time) error
 }
 
+// createBatchSize caps the number of rows per INSERT statement so that large
+// batches stay well below the Postgres bind parameter limit.
+const createBatchSize = 1000
+
 type messageRepository struct {
 	db     *gorm.DB
 	logger *logrus.Logger
@@ -29,16 +58,22 @@ func NewMessageRepository(db *gorm.DB, logger *logrus.Logger) MessageRepository
 	}
 }
 
-func (r *messageRepository) Create(ctx context.Context, message model.Message) error {
-	return r.db.Create(&message).Error
+// Create inserts the message and populates its generated ID and timestamps.
+func (r *messageRepository) Create(ctx context.Context, message *model.Message) error {
+	return r.db.WithContext(ctx).Create(message).Error
 }
 
-func (r *messageRepository) Update(ctx context.Context, id int, status model.MessageStatus) error {
+// CreateMany inserts the messages in chunks within a single transaction and
+// populates their generated IDs in place.
+func (r *messageRepository) CreateMany(ctx context.Context, messages []model.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
 	return r.db.WithContext(ctx).
-		Model(&model.Message{}).
-		Where("id = ?", id).
-		Update("status", status).
-		Error
+		Transaction(func(tx *gorm.DB) error {
+			return tx.CreateInBatches(&messages, createBatchSize).Error
+		})
 }
 
 func (r *messageRepository) Delete(ctx context.Context, id int) error {
@@ -48,18 +83,310 @@ func (r *messageRepository) Delete(ctx context.Context, id int) error {
 		Error
 }
 
-func (r *messageRepository) GetAll(ctx context.Context, status ...model.MessageStatus) ([]model.Message, error) {
+// List returns the messages matching the filter in ascending ID order,
+// starting after filter.AfterID.
+func (r *messageRepository) List(ctx context.Context, filter model.MessageFilter) ([]model.Message, error) {
 	var messages []model.Message
 	query := r.db.WithContext(ctx)
 
-	if len(status) > 0 {
-		query = query.Where("status IN ?", status)
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.Recipient != "" {
+		query = query.Where("recipient = ?", filter.Recipient)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at >= ?", filter.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+	if filter.AfterID > 0 {
+		query = query.Where("id > ?", filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	err := query.Order("id").Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetByID returns the message with the given ID including its delivery
+// history, or ErrMessageNotFound if it does not exist or has been deleted.
+func (r *messageRepository) GetByID(ctx context.Context, id int) (*model.Message, error) {
+	var message model.Message
+	err := r.db.WithContext(ctx).
+		Preload("History", func(db *gorm.DB) *gorm.DB {
+			return db.Order("attempt, part_index")
+		}).
+		Preload("Parts", func(db *gorm.DB) *gorm.DB {
+			return db.Order("part_index")
+		}).
+		Where("id = ?", id).
+		First(&message).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &message, nil
+}
+
+// Cancel soft-deletes the message if it is still waiting to be dispatched. It
+// returns ErrMessageNotFound if the message does not exist and
+// ErrMessageNotCancellable if it has already been dispatched.
+func (r *messageRepository) Cancel(ctx context.Context, id int) error {
+	result := r.db.WithContext(ctx).
+		Where("id = ? AND status IN ?", id, cancellableStatuses).
+		Delete(&model.Message{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	// Nothing was deleted, find out whether the message exists at all.
+	if _, err := r.GetByID(ctx, id); err != nil {
+		return err
 	}
 
-	err := query.Find(&messages).Error
+	return ErrMessageNotCancellable
+}
+
+// RecordDelivery stores the outcome of a send attempt on the message,
+// releases its lease, increments its attempt counter and appends the attempt
+// to its history. Successful deliveries keep the previous last_error so that
+// earlier failures remain visible. It returns ErrLeaseLost if the message is
+// no longer leased by delivery.LeaseOwner.
+func (r *messageRepository) RecordDelivery(ctx context.Context, id int, delivery model.Delivery) error {
+	updates := map[string]interface{}{
+		"status":           delivery.Status,
+		"attempts":         gorm.Expr("attempts + 1"),
+		"lease_owner":      "",
+		"lease_expires_at": nil,
+	}
+
+	if delivery.Status == model.StatusSent {
+		updates["provider_message_id"] = delivery.ProviderMessageID
+		updates["sent_at"] = delivery.FinishedAt
+	} else {
+		updates["last_error"] = delivery.Error
+	}
+	updates["next_attempt_at"] = delivery.NextAttemptAt
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ? AND lease_owner = ?", id, model.StatusProcessing, delivery.LeaseOwner).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+
+		var attempt int
+		err := tx.Model(&model.Message{}).
+			Select("attempts").
+			Where("id = ?", id).
+			Scan(&attempt).
+			Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Create(&model.MessageAttempt{
+			MessageID:         id,
+			Attempt:           attempt,
+			PartIndex:         delivery.PartIndex,
+			StartedAt:         delivery.StartedAt,
+			FinishedAt:        delivery.FinishedAt,
+			HTTPStatus:        delivery.HTTPStatus,
+			ProviderMessageID: delivery.ProviderMessageID,
+			Error:             delivery.Error,
+		}).Error
+	})
+}
+
+// RecordPart stores a part of a multipart message that the provider
+// accepted, so that it is not sent again even if the attempt never completes,
+// and extends the lease of the message to expiresAt, so that a long multipart
+// send does not outlive its lease. It returns ErrLeaseLost if the message is
+// no longer leased by owner.
+func (r *messageRepository) RecordPart(ctx context.Context, id int, owner string, part model.MessagePart, expiresAt time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Message{}).
+			Where("id = ? AND status = ? AND lease_owner = ?", id, model.StatusProcessing, owner).
+			Update("lease_expires_at", expiresAt)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLeaseLost
+		}
+
+		part.MessageID = id
+		return tx.Create(&part).Error
+	})
+}
+
+// Claim atomically leases the due messages to owner for the given duration
+// and returns them. Due messages are pending messages and failed messages
+// whose retry backoff has elapsed at now. Rows locked by a concurrent claim
+// are skipped, so every message is handed to exactly one dispatcher instance.
+// At most limit messages are claimed, oldest first, and they are returned in
+// that order.
+func (r *messageRepository) Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.Message, error) {
+	var messages []model.Message
+
+	due := r.db.Model(&model.Message{}).
+		Select("id").
+		Where("status IN ?", []model.MessageStatus{model.StatusPending, model.StatusFailed}).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+		Order("created_at, id").
+		Limit(limit).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&messages).
+			Clauses(clause.Returning{}).
+			Where("id IN (?)", due).
+			Updates(map[string]interface{}{
+				"status":           model.StatusProcessing,
+				"lease_owner":      owner,
+				"lease_expires_at": now.Add(lease),
+			}).
+			Error
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+
+		// Parts delivered by earlier attempts are skipped when resending.
+		ids := make([]int, len(messages))
+		for i, msg := range messages {
+			ids[i] = msg.ID
+		}
+
+		var parts []model.MessagePart
+		err = tx.Where("message_id IN ?", ids).
+			Order("message_id, part_index").
+			Find(&parts).
+			Error
+		if err != nil {
+			return err
+		}
+
+		partsByMessage := make(map[int][]model.MessagePart)
+		for _, part := range parts {
+			partsByMessage[part.MessageID] = append(partsByMessage[part.MessageID], part)
+		}
+		for i := range messages {
+			messages[i].Parts = partsByMessage[messages[i].ID]
+		}
+
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	// RETURNING does not preserve the order of the subquery.
+	sort.Slice(messages, func(i, j int) bool {
+		if !messages[i].CreatedAt.Equal(messages[j].CreatedAt) {
+			return messages[i].CreatedAt.Before(messages[j].CreatedAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+
 	return messages, nil
 }
+
+// RenewLease extends the lease of a message held by owner to expiresAt. It
+// returns ErrLeaseLost if the message is no longer processing under owner,
+// for example because its lease expired and it was claimed again.
+func (r *messageRepository) RenewLease(ctx context.Context, id int, owner string, expiresAt time.Time) error {
+	result := r.db.WithContext(ctx).
+		Model(&model.Message{}).
+		Where("id = ? AND status = ? AND lease_owner = ?", id, model.StatusProcessing, owner).
+		Update("lease_expires_at", expiresAt)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ReleaseExpired returns the processing messages whose lease has expired at
+// now to pending, and records their abandoned attempt with an unknown
+// outcome. Messages that have used up maxAttempts with the abandoned attempt
+// are marked dead instead, so that a message whose send never completes is
+// not retried forever. It returns the number of released messages.
+func (r *messageRepository) ReleaseExpired(ctx context.Context, now time.Time, maxAttempts int) (int, error) {
+	var expired []model.Message
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND lease_expires_at < ?", model.StatusProcessing, now).
+			Find(&expired).
+			Error
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+
+		idsByStatus := make(map[model.MessageStatus][]int)
+		attempts := make([]model.MessageAttempt, len(expired))
+		for i, msg := range expired {
+			status := model.StatusPending
+			if msg.Attempts+1 >= maxAttempts {
+				status = model.StatusDead
+			}
+			idsByStatus[status] = append(idsByStatus[status], msg.ID)
+			attempts[i] = model.MessageAttempt{
+				MessageID:  msg.ID,
+				Attempt:    msg.Attempts + 1,
+				StartedAt:  msg.UpdatedAt,
+				FinishedAt: now,
+				Error:      UnknownOutcome,
+			}
+		}
+
+		for _, status := range []model.MessageStatus{model.StatusPending, model.StatusDead} {
+			ids := idsByStatus[status]
+			if len(ids) == 0 {
+				continue
+			}
+
+			err = tx.Model(&model.Message{}).
+				Where("id IN ?", ids).
+				Updates(map[string]interface{}{
+					"status":           status,
+					"attempts":         gorm.Expr("attempts + 1"),
+					"last_error":       UnknownOutcome,
+					"lease_owner":      "",
+					"lease_expires_at": nil,
+				}).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&attempts).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(expired), nil
+}
@@ -2,7 +2,11 @@ package repository
 
 import (
 	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/ecoderat/dispatch-go/internal/model"
@@ -21,124 +25,552 @@ func setupTestDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock, func()) {
 	return gormDB, mock, cleanup
 }
 
-func TestMessageRepository_GetAll(t *testing.T) {
+// insertMessageQuery returns the statement gorm issues to insert the given
+// number of messages.
+func insertMessageQuery(rows int) string {
+	columns := []string{"recipient", "content", "status", "provider_message_id", "attempts", "last_error", "sent_at", "next_attempt_at", "lease_owner", "lease_expires_at", "created_at", "updated_at", "deleted_at"}
+
+	values := make([]string, rows)
+	for i := range values {
+		placeholders := make([]string, len(columns))
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*len(columns)+j+1)
+		}
+		values[i] = "(" + strings.Join(placeholders, ",") + ")"
+	}
+
+	return fmt.Sprintf(`INSERT INTO "message" ("%s") VALUES %s RETURNING "id"`, strings.Join(columns, `","`), strings.Join(values, ","))
+}
+
+// insertMessageArgs returns the arguments of a newly created message in the
+// order of insertMessageQuery.
+func insertMessageArgs(recipient, content, status string) []driver.Value {
+	return []driver.Value{
+		recipient,
+		content,
+		status,
+		"",               // provider_message_id
+		0,                // attempts
+		"",               // last_error
+		nil,              // sent_at
+		nil,              // next_attempt_at
+		"",               // lease_owner
+		nil,              // lease_expires_at
+		sqlmock.AnyArg(), // created_at
+		sqlmock.AnyArg(), // updated_at
+		sqlmock.AnyArg(), // deleted_at
+	}
+}
+
+func TestMessageRepository_Create(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
 	logger := &logrus.Logger{}
 	repo := NewMessageRepository(db, logger)
 
-	query := `SELECT * FROM "message" WHERE "message"."deleted_at" IS NULL`
+	query := insertMessageQuery(1)
 
-	rows := sqlmock.NewRows([]string{"id", "recipient", "content", "status"}).
-		AddRow(1, "+123", "hi", "pending")
-	mock.ExpectQuery(query).WillReturnRows(rows)
+	msg := model.Message{Recipient: "+123", Content: "hi", Status: "pending"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).
+		WithArgs(insertMessageArgs(msg.Recipient, msg.Content, string(msg.Status))...).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
 
-	msgs, err := repo.GetAll(context.Background())
+	err := repo.Create(context.Background(), &msg)
 	assert.NoError(t, err)
-	assert.Len(t, msgs, 1)
-	assert.Equal(t, "+123", msgs[0].Recipient)
-	assert.Equal(t, "hi", msgs[0].Content)
-	assert.Equal(t, "pending", string(msgs[0].Status))
+	assert.Equal(t, 1, msg.ID)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestMessageRepository_GetAll_WithStatus(t *testing.T) {
+func TestMessageRepository_Delete(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
 	logger := &logrus.Logger{}
 	repo := NewMessageRepository(db, logger)
 
-	query := `SELECT * FROM "message" WHERE status IN ($1,$2) AND "message"."deleted_at" IS NULL`
+	query := `UPDATE "message" SET "deleted_at"=$1 WHERE id = $2 AND "message"."deleted_at" IS NULL`
+	messageID := 1
+
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WithArgs(sqlmock.AnyArg(), messageID).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err := repo.Delete(context.Background(), messageID)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
+func TestMessageRepository_CreateMany(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	query := insertMessageQuery(2)
+
+	msgs := []model.Message{
+		{Recipient: "+123", Content: "hi", Status: model.StatusPending},
+		{Recipient: "+456", Content: "hello", Status: model.StatusPending},
+	}
+	mock.ExpectBegin()
+	args := append(insertMessageArgs("+123", "hi", "pending"), insertMessageArgs("+456", "hello", "pending")...)
+	mock.ExpectQuery(query).WithArgs(args...).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	err := repo.CreateMany(context.Background(), msgs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, msgs[0].ID)
+	assert.Equal(t, 2, msgs[1].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_CreateMany_Fails(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	query := insertMessageQuery(1)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	err := repo.CreateMany(context.Background(), []model.Message{{Recipient: "+123", Content: "hi", Status: model.StatusPending}})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_List(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	query := `SELECT * FROM "message" WHERE status IN ($1,$2) AND recipient = $3 AND created_at >= $4 AND created_at < $5 AND id > $6 AND "message"."deleted_at" IS NULL ORDER BY id LIMIT $7`
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
 	rows := sqlmock.NewRows([]string{"id", "recipient", "content", "status"}).
-		AddRow(1, "+123", "hi", "pending").
-		AddRow(2, "+456", "hello", "failed")
-	mock.ExpectQuery(query).WithArgs("pending", "failed").WillReturnRows(rows)
+		AddRow(11, "+123", "hi", "sent")
+	mock.ExpectQuery(query).WithArgs("sent", "failed", "+123", from, to, 10, 51).WillReturnRows(rows)
 
-	msgs, err := repo.GetAll(context.Background(), model.StatusPending, model.StatusFailed)
+	msgs, err := repo.List(context.Background(), model.MessageFilter{
+		Statuses:      []model.MessageStatus{model.StatusSent, model.StatusFailed},
+		Recipient:     "+123",
+		CreatedAfter:  from,
+		CreatedBefore: to,
+		AfterID:       10,
+		Limit:         51,
+	})
 	assert.NoError(t, err)
-	assert.Len(t, msgs, 2)
-	assert.Equal(t, "+123", msgs[0].Recipient)
-	assert.Equal(t, "hi", msgs[0].Content)
-	assert.Equal(t, "pending", string(msgs[0].Status))
-	assert.Equal(t, "+456", msgs[1].Recipient)
-	assert.Equal(t, "hello", msgs[1].Content)
-	assert.Equal(t, "failed", string(msgs[1].Status))
+	assert.Len(t, msgs, 1)
+	assert.Equal(t, 11, msgs[0].ID)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestMessageRepository_GetAll_Fails(t *testing.T) {
+func TestMessageRepository_List_NoFilter(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
 	logger := &logrus.Logger{}
 	repo := NewMessageRepository(db, logger)
 
-	query := `SELECT * FROM "message" WHERE "message"."deleted_at" IS NULL`
+	query := `SELECT * FROM "message" WHERE "message"."deleted_at" IS NULL ORDER BY id`
 
 	mock.ExpectQuery(query).WillReturnError(assert.AnError)
 
-	msgs, err := repo.GetAll(context.Background())
+	msgs, err := repo.List(context.Background(), model.MessageFilter{})
 	assert.Error(t, err)
 	assert.Nil(t, msgs)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestMessageRepository_Create(t *testing.T) {
+func TestMessageRepository_GetByID(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
 	logger := &logrus.Logger{}
 	repo := NewMessageRepository(db, logger)
 
-	query := `INSERT INTO "message" ("recipient","content","status","created_at","updated_at","deleted_at") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`
+	query := `SELECT * FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL ORDER BY "message"."id" LIMIT $2`
+
+	rows := sqlmock.NewRows([]string{"id", "recipient", "content", "status"}).
+		AddRow(7, "+123", "hi", "sent")
+	mock.ExpectQuery(query).WithArgs(7, 1).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT * FROM "message_attempt" WHERE "message_attempt"."message_id" = $1 ORDER BY attempt, part_index`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "message_id", "attempt", "error"}).
+			AddRow(1, 7, 1, "timeout").
+			AddRow(2, 7, 2, ""))
+	mock.ExpectQuery(`SELECT * FROM "message_part" WHERE "message_part"."message_id" = $1 ORDER BY part_index`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "message_id", "part_index", "provider_message_id"}).
+			AddRow(1, 7, 1, "p-1"))
+
+	msg, err := repo.GetByID(context.Background(), 7)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, msg.ID)
+	assert.Equal(t, "+123", msg.Recipient)
+	assert.Len(t, msg.History, 2)
+	assert.Equal(t, "timeout", msg.History[0].Error)
+	assert.Len(t, msg.Parts, 1)
+	assert.Equal(t, "p-1", msg.Parts[0].ProviderMessageID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_GetByID_NotFound(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	query := `SELECT * FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL ORDER BY "message"."id" LIMIT $2`
+
+	mock.ExpectQuery(query).WithArgs(7, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	msg, err := repo.GetByID(context.Background(), 7)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	assert.Nil(t, msg)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_Cancel(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	query := `UPDATE "message" SET "deleted_at"=$1 WHERE (id = $2 AND status IN ($3,$4)) AND "message"."deleted_at" IS NULL`
 
-	msg := model.Message{Recipient: "+123", Content: "hi", Status: "pending"}
 	mock.ExpectBegin()
-	mock.ExpectQuery(query).WithArgs(
-		msg.Recipient,
-		msg.Content,
-		string(msg.Status),
-		sqlmock.AnyArg(), // created_at
-		sqlmock.AnyArg(), // updated_at
-		sqlmock.AnyArg(), // deleted_at
-	).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(query).WithArgs(sqlmock.AnyArg(), 1, "pending", "failed").WillReturnResult(sqlmock.NewResult(0, 1))
 	mock.ExpectCommit()
 
-	err := repo.Create(context.Background(), msg)
+	err := repo.Cancel(context.Background(), 1)
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestMessageRepository_Update(t *testing.T) {
+func TestMessageRepository_Cancel_NotCancellable(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "deleted_at"=$1 WHERE (id = $2 AND status IN ($3,$4)) AND "message"."deleted_at" IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 1, "pending", "failed").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT * FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL ORDER BY "message"."id" LIMIT $2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, "sent"))
+	mock.ExpectQuery(`SELECT * FROM "message_attempt" WHERE "message_attempt"."message_id" = $1 ORDER BY attempt, part_index`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT * FROM "message_part" WHERE "message_part"."message_id" = $1 ORDER BY part_index`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	err := repo.Cancel(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrMessageNotCancellable)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_Cancel_NotFound(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "deleted_at"=$1 WHERE (id = $2 AND status IN ($3,$4)) AND "message"."deleted_at" IS NULL`).
+		WithArgs(sqlmock.AnyArg(), 1, "pending", "failed").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT * FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL ORDER BY "message"."id" LIMIT $2`).
+		WithArgs(1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	err := repo.Cancel(context.Background(), 1)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RecordDelivery_Sent(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
 	logger := &logrus.Logger{}
 	repo := NewMessageRepository(db, logger)
 
+	startedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	finishedAt := startedAt.Add(time.Second)
+
 	mock.ExpectBegin()
-	mock.ExpectExec(`UPDATE "message" SET "status"=$1,"updated_at"=$2 WHERE id = $3 AND "message"."deleted_at" IS NULL`).
-		WithArgs("sent", sqlmock.AnyArg(), 1).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"lease_expires_at"=$1,"lease_owner"=$2,"next_attempt_at"=$3,"provider_message_id"=$4,"sent_at"=$5,"status"=$6,"updated_at"=$7 WHERE (id = $8 AND status = $9 AND lease_owner = $10) AND "message"."deleted_at" IS NULL`).
+		WithArgs(nil, "", nil, "prov-1", finishedAt, "sent", sqlmock.AnyArg(), 1, "processing", "node-a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "attempts" FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(2))
+	mock.ExpectQuery(`INSERT INTO "message_attempt" ("message_id","attempt","part_index","started_at","finished_at","http_status","provider_message_id","error") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`).
+		WithArgs(1, 2, 0, startedAt, finishedAt, 202, "prov-1", "").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
-	err := repo.Update(context.Background(), 1, "sent")
+	err := repo.RecordDelivery(context.Background(), 1, model.Delivery{
+		LeaseOwner:        "node-a",
+		Status:            model.StatusSent,
+		ProviderMessageID: "prov-1",
+		HTTPStatus:        202,
+		StartedAt:         startedAt,
+		FinishedAt:        finishedAt,
+	})
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestMessageRepository_Delete(t *testing.T) {
+func TestMessageRepository_RecordDelivery_Failed(t *testing.T) {
 	db, mock, cleanup := setupTestDB(t)
 	defer cleanup()
 	logger := &logrus.Logger{}
 	repo := NewMessageRepository(db, logger)
 
-	query := `UPDATE "message" SET "deleted_at"=$1 WHERE id = $2 AND "message"."deleted_at" IS NULL`
-	messageID := 1
+	nextAttemptAt := time.Date(2025, 1, 1, 12, 5, 0, 0, time.UTC)
 
 	mock.ExpectBegin()
-	mock.ExpectExec(query).WithArgs(sqlmock.AnyArg(), messageID).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"last_error"=$1,"lease_expires_at"=$2,"lease_owner"=$3,"next_attempt_at"=$4,"status"=$5,"updated_at"=$6 WHERE (id = $7 AND status = $8 AND lease_owner = $9) AND "message"."deleted_at" IS NULL`).
+		WithArgs("boom", nil, "", nextAttemptAt, "failed", sqlmock.AnyArg(), 1, "processing", "node-a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "attempts" FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "message_attempt" ("message_id","attempt","part_index","started_at","finished_at","http_status","provider_message_id","error") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`).
+		WithArgs(1, 1, 0, sqlmock.AnyArg(), sqlmock.AnyArg(), 0, "", "boom").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 	mock.ExpectCommit()
 
-	err := repo.Delete(context.Background(), messageID)
+	err := repo.RecordDelivery(context.Background(), 1, model.Delivery{
+		LeaseOwner:    "node-a",
+		Status:        model.StatusFailed,
+		Error:         "boom",
+		StartedAt:     time.Now(),
+		FinishedAt:    time.Now(),
+		NextAttemptAt: &nextAttemptAt,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RecordDelivery_PartialFailure(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	sentAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"last_error"=$1,"lease_expires_at"=$2,"lease_owner"=$3,"next_attempt_at"=$4,"status"=$5,"updated_at"=$6 WHERE (id = $7 AND status = $8 AND lease_owner = $9) AND "message"."deleted_at" IS NULL`).
+		WithArgs("part 3: boom", nil, "", nil, "failed", sqlmock.AnyArg(), 1, "processing", "node-a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT "attempts" FROM "message" WHERE id = $1 AND "message"."deleted_at" IS NULL`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"attempts"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "message_attempt" ("message_id","attempt","part_index","started_at","finished_at","http_status","provider_message_id","error") VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING "id"`).
+		WithArgs(1, 1, 3, sqlmock.AnyArg(), sentAt, 0, "", "part 3: boom").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := repo.RecordDelivery(context.Background(), 1, model.Delivery{
+		LeaseOwner: "node-a",
+		Status:     model.StatusFailed,
+		Error:      "part 3: boom",
+		PartIndex:  3,
+		StartedAt:  sentAt.Add(-time.Second),
+		FinishedAt: sentAt,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RecordDelivery_LeaseLost(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"lease_expires_at"=$1,"lease_owner"=$2,"next_attempt_at"=$3,"provider_message_id"=$4,"sent_at"=$5,"status"=$6,"updated_at"=$7 WHERE (id = $8 AND status = $9 AND lease_owner = $10) AND "message"."deleted_at" IS NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := repo.RecordDelivery(context.Background(), 1, model.Delivery{LeaseOwner: "node-a", Status: model.StatusSent})
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RecordDelivery_Fails(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"last_error"=$1,"lease_expires_at"=$2,"lease_owner"=$3,"next_attempt_at"=$4,"status"=$5,"updated_at"=$6 WHERE (id = $7 AND status = $8 AND lease_owner = $9) AND "message"."deleted_at" IS NULL`).
+		WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	err := repo.RecordDelivery(context.Background(), 1, model.Delivery{LeaseOwner: "node-a", Status: model.StatusFailed, Error: "boom"})
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RecordPart(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	sentAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	expiresAt := sentAt.Add(5 * time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "lease_expires_at"=$1,"updated_at"=$2 WHERE (id = $3 AND status = $4 AND lease_owner = $5) AND "message"."deleted_at" IS NULL`).
+		WithArgs(expiresAt, sqlmock.AnyArg(), 1, "processing", "node-a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "message_part" ("message_id","part_index","provider_message_id","content","encoding","sent_at") VALUES ($1,$2,$3,$4,$5,$6) RETURNING "id"`).
+		WithArgs(1, 2, "p-2", "world [2/3]", "gsm7", sentAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	err := repo.RecordPart(context.Background(), 1, "node-a", model.MessagePart{PartIndex: 2, ProviderMessageID: "p-2", Content: "world [2/3]", Encoding: "gsm7", SentAt: sentAt}, expiresAt)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RecordPart_LeaseLost(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "message" SET "lease_expires_at"=$1,"updated_at"=$2 WHERE (id = $3 AND status = $4 AND lease_owner = $5) AND "message"."deleted_at" IS NULL`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	err := repo.RecordPart(context.Background(), 1, "node-a", model.MessagePart{PartIndex: 2}, time.Now())
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_Claim(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	query := `UPDATE "message" SET "lease_expires_at"=$1,"lease_owner"=$2,"status"=$3,"updated_at"=$4 WHERE id IN (SELECT "id" FROM "message" WHERE status IN ($5,$6) AND (next_attempt_at IS NULL OR next_attempt_at <= $7) AND "message"."deleted_at" IS NULL ORDER BY created_at, id LIMIT $8 FOR UPDATE SKIP LOCKED) AND "message"."deleted_at" IS NULL RETURNING *`
+
+	rows := sqlmock.NewRows([]string{"id", "recipient", "content", "status", "attempts", "lease_owner", "created_at"}).
+		AddRow(2, "+456", "hello", "processing", 2, "node-a", now.Add(-time.Hour)).
+		AddRow(1, "+123", "hi", "processing", 0, "node-a", now.Add(-time.Hour)).
+		AddRow(3, "+789", "hey", "processing", 0, "node-a", now.Add(-2*time.Hour))
+	mock.ExpectBegin()
+	mock.ExpectQuery(query).WithArgs(now.Add(5*time.Minute), "node-a", "processing", sqlmock.AnyArg(), "pending", "failed", now, 100).WillReturnRows(rows)
+	mock.ExpectQuery(`SELECT * FROM "message_part" WHERE message_id IN ($1,$2,$3) ORDER BY message_id, part_index`).
+		WithArgs(2, 1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "message_id", "part_index", "provider_message_id"}).
+			AddRow(1, 2, 1, "p-1").
+			AddRow(2, 2, 2, "p-2"))
+	mock.ExpectCommit()
+
+	msgs, err := repo.Claim(context.Background(), "node-a", now, 5*time.Minute, 100)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+	assert.Equal(t, []int{3, 1, 2}, []int{msgs[0].ID, msgs[1].ID, msgs[2].ID})
+	assert.Equal(t, 2, msgs[2].Attempts)
+	assert.Equal(t, "node-a", msgs[2].LeaseOwner)
+	assert.Len(t, msgs[2].Parts, 2)
+	assert.Empty(t, msgs[0].Parts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_RenewLease(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	expiresAt := time.Date(2025, 1, 1, 12, 5, 0, 0, time.UTC)
+	query := `UPDATE "message" SET "lease_expires_at"=$1,"updated_at"=$2 WHERE (id = $3 AND status = $4 AND lease_owner = $5) AND "message"."deleted_at" IS NULL`
+
+	mock.ExpectBegin()
+	mock.ExpectExec(query).
+		WithArgs(expiresAt, sqlmock.AnyArg(), 1, "processing", "node-a").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(query).
+		WithArgs(expiresAt, sqlmock.AnyArg(), 2, "processing", "node-a").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	assert.NoError(t, repo.RenewLease(context.Background(), 1, "node-a", expiresAt))
+	assert.ErrorIs(t, repo.RenewLease(context.Background(), 2, "node-a", expiresAt), ErrLeaseLost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_ReleaseExpired(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	claimedAt := now.Add(-10 * time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT * FROM "message" WHERE (status = $1 AND lease_expires_at < $2) AND "message"."deleted_at" IS NULL FOR UPDATE SKIP LOCKED`).
+		WithArgs("processing", now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "attempts", "updated_at"}).
+			AddRow(3, "processing", 1, claimedAt).
+			AddRow(4, "processing", 4, claimedAt))
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"last_error"=$1,"lease_expires_at"=$2,"lease_owner"=$3,"status"=$4,"updated_at"=$5 WHERE id IN ($6) AND "message"."deleted_at" IS NULL`).
+		WithArgs(UnknownOutcome, nil, "", "pending", sqlmock.AnyArg(), 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "message" SET "attempts"=attempts + 1,"last_error"=$1,"lease_expires_at"=$2,"lease_owner"=$3,"status"=$4,"updated_at"=$5 WHERE id IN ($6) AND "message"."deleted_at" IS NULL`).
+		WithArgs(UnknownOutcome, nil, "", "dead", sqlmock.AnyArg(), 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "message_attempt" ("message_id","attempt","part_index","started_at","finished_at","http_status","provider_message_id","error") VALUES ($1,$2,$3,$4,$5,$6,$7,$8),($9,$10,$11,$12,$13,$14,$15,$16) RETURNING "id"`).
+		WithArgs(3, 2, 0, claimedAt, now, 0, "", UnknownOutcome, 4, 5, 0, claimedAt, now, 0, "", UnknownOutcome).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	released, err := repo.ReleaseExpired(context.Background(), now, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, released)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMessageRepository_ReleaseExpired_NothingExpired(t *testing.T) {
+	db, mock, cleanup := setupTestDB(t)
+	defer cleanup()
+	logger := &logrus.Logger{}
+	repo := NewMessageRepository(db, logger)
+
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT * FROM "message" WHERE (status = $1 AND lease_expires_at < $2) AND "message"."deleted_at" IS NULL FOR UPDATE SKIP LOCKED`).
+		WithArgs("processing", now).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	released, err := repo.ReleaseExpired(context.Background(), now, 5)
 	assert.NoError(t, err)
+	assert.Zero(t, released)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,128 @@
+package segment
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Encoding is the character encoding an SMS is sent in.
+type Encoding string
+
+const (
+	// GSM7 is the GSM 03.38 default alphabet, with 7 bits per character.
+	GSM7 Encoding = "gsm7"
+	// UCS2 is used for any content outside the GSM 03.38 alphabet, with 16
+	// bits per character.
+	UCS2 Encoding = "ucs2"
+)
+
+const (
+	// GSM7SingleLimit is the number of septets in a single GSM-7 SMS.
+	GSM7SingleLimit = 160
+	// GSM7PartLimit is the number of septets in one part of a multipart
+	// GSM-7 SMS, the rest of the part holds the concatenation header.
+	GSM7PartLimit = 153
+	// UCS2SingleLimit is the number of UTF-16 code units in a single UCS-2
+	// SMS.
+	UCS2SingleLimit = 70
+	// UCS2PartLimit is the number of UTF-16 code units in one part of a
+	// multipart UCS-2 SMS.
+	UCS2PartLimit = 67
+)
+
+// gsm7Basic is the GSM 03.38 basic character set, without the escape
+// character that introduces the extension table.
+const gsm7Basic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+// gsm7Extension is the GSM 03.38 extension table. Each of these characters
+// is sent as an escape followed by the character and takes two septets.
+const gsm7Extension = "\f^{}\\[~]|€"
+
+// Detect returns GSM7 if every character of content is in the GSM 03.38
+// basic or extension table, and UCS2 otherwise.
+func Detect(content string) Encoding {
+	for _, r := range content {
+		if !strings.ContainsRune(gsm7Basic, r) && !strings.ContainsRune(gsm7Extension, r) {
+			return UCS2
+		}
+	}
+	return GSM7
+}
+
+// Length returns the size of content in enc: septets for GSM-7, where
+// extension characters count twice, and UTF-16 code units for UCS-2, where
+// characters outside the Basic Multilingual Plane such as emoji count twice.
+func Length(content string, enc Encoding) int {
+	n := 0
+	for _, r := range content {
+		n += width(r, enc)
+	}
+	return n
+}
+
+// Split detects the encoding of content and splits it into parts that each
+// fit in one SMS. Content that fits in a single SMS is returned as one part.
+// Otherwise every part holds at most the multipart limit minus reserve,
+// leaving room for a part counter added by the caller. Parts are split
+// between characters, preferably after whitespace, and concatenating them
+// yields content again.
+func Split(content string, reserve int) (Encoding, []string) {
+	enc := Detect(content)
+
+	single, part := GSM7SingleLimit, GSM7PartLimit
+	if enc == UCS2 {
+		single, part = UCS2SingleLimit, UCS2PartLimit
+	}
+
+	if Length(content, enc) <= single {
+		return enc, []string{content}
+	}
+
+	limit := max(part-reserve, 1)
+	runes := []rune(content)
+
+	var parts []string
+	for start := 0; start < len(runes); {
+		end, used, lastBreak := start, 0, -1
+		for end < len(runes) {
+			w := width(runes[end], enc)
+			if used+w > limit {
+				break
+			}
+			used += w
+			end++
+			if unicode.IsSpace(runes[end-1]) {
+				lastBreak = end
+			}
+		}
+
+		switch {
+		case end == start:
+			// A character wider than the limit still has to go somewhere.
+			end++
+		case end < len(runes) && lastBreak-start > (end-start)/2:
+			// Break after the last word unless that leaves the part less
+			// than half full.
+			end = lastBreak
+		}
+
+		parts = append(parts, string(runes[start:end]))
+		start = end
+	}
+
+	return enc, parts
+}
+
+func width(r rune, enc Encoding) int {
+	if enc == UCS2 {
+		if r > 0xFFFF {
+			return 2
+		}
+		return 1
+	}
+	if strings.ContainsRune(gsm7Extension, r) {
+		return 2
+	}
+	return 1
+}
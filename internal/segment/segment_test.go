@@ -0,0 +1,86 @@
+package segment
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	assert.Equal(t, GSM7, Detect("Hello, world! @£$ÄÖÑÜ"))
+	assert.Equal(t, GSM7, Detect("Price: 5€ [promo] {code} ~100|^"))
+	assert.Equal(t, UCS2, Detect("Şifreniz: 1234, iyi günler"))
+	assert.Equal(t, UCS2, Detect("See you soon 👋"))
+	assert.Equal(t, GSM7, Detect(""))
+}
+
+func TestLength(t *testing.T) {
+	assert.Equal(t, 5, Length("hello", GSM7))
+	assert.Equal(t, 8, Length("5€ []", GSM7), "extension characters take two septets")
+	assert.Equal(t, 3, Length("ğüş", UCS2))
+	assert.Equal(t, 4, Length("hi👋", UCS2), "emoji take a surrogate pair")
+}
+
+func TestSplit_Single(t *testing.T) {
+	content := strings.Repeat("a", GSM7SingleLimit)
+	enc, parts := Split(content, 6)
+	assert.Equal(t, GSM7, enc)
+	assert.Equal(t, []string{content}, parts)
+
+	content = strings.Repeat("ş", UCS2SingleLimit)
+	enc, parts = Split(content, 6)
+	assert.Equal(t, UCS2, enc)
+	assert.Equal(t, []string{content}, parts)
+}
+
+func TestSplit_GSM7(t *testing.T) {
+	content := strings.Repeat("a", GSM7SingleLimit+1)
+	enc, parts := Split(content, 0)
+	assert.Equal(t, GSM7, enc)
+	assert.Len(t, parts, 2)
+	assert.Len(t, parts[0], GSM7PartLimit)
+	assert.Equal(t, content, strings.Join(parts, ""))
+}
+
+func TestSplit_GSM7ExtensionCountsDouble(t *testing.T) {
+	// 100 extension characters take 200 septets.
+	content := strings.Repeat("€", 100)
+	enc, parts := Split(content, 0)
+	assert.Equal(t, GSM7, enc)
+	assert.Len(t, parts, 2)
+	for _, part := range parts {
+		assert.LessOrEqual(t, Length(part, enc), GSM7PartLimit)
+	}
+	assert.Equal(t, content, strings.Join(parts, ""))
+}
+
+func TestSplit_UCS2KeepsRunesIntact(t *testing.T) {
+	content := strings.Repeat("Çğış👋", 30)
+	enc, parts := Split(content, 6)
+	assert.Equal(t, UCS2, enc)
+	assert.Greater(t, len(parts), 1)
+	for _, part := range parts {
+		assert.True(t, utf8.ValidString(part))
+		assert.LessOrEqual(t, Length(part, enc), UCS2PartLimit-6)
+	}
+	assert.Equal(t, content, strings.Join(parts, ""))
+}
+
+func TestSplit_PrefersWordBoundaries(t *testing.T) {
+	content := strings.Repeat("word ", 40)
+	_, parts := Split(content, 0)
+	assert.Greater(t, len(parts), 1)
+	for _, part := range parts[:len(parts)-1] {
+		assert.True(t, strings.HasSuffix(part, " "), "part %q should end after a word", part)
+	}
+	assert.Equal(t, content, strings.Join(parts, ""))
+}
+
+func TestSplit_LongWordIsCut(t *testing.T) {
+	content := "a " + strings.Repeat("b", 300)
+	_, parts := Split(content, 0)
+	assert.Len(t, parts[0], GSM7PartLimit)
+	assert.Equal(t, content, strings.Join(parts, ""))
+}
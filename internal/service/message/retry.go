@@ -0,0 +1,52 @@
+package message
+
+import (
+	"time"
+)
+
+// RetryPolicy controls how failed messages are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts after which a message is marked
+	// dead instead of failed.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry. It doubles with every
+	// further attempt.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between two attempts.
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Minute,
+		MaxDelay:    time.Hour,
+	}
+}
+
+// Backoff returns the wait before the attempt following the given one.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Option configures optional behaviour of the message service.
+type Option func(*service)
+
+// WithRetryPolicy overrides the default retry policy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(s *service) {
+		s.retryPolicy = policy
+	}
+}
@@ -3,6 +3,11 @@ package message
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/ecoderat/dispatch-go/internal/driver"
 	"github.com/ecoderat/dispatch-go/internal/model"
@@ -11,85 +16,457 @@ import (
 )
 
 var (
-	ErrGetSentMessages   = errors.New("service: failed to get sent messages")
-	ErrGetUnsentMessages = errors.New("service: failed to get unsent messages")
-	ErrUpdateMessage     = errors.New("service: failed to update message status")
-	ErrSendMessage       = errors.New("service: failed to send message")
+	ErrClaimMessages    = errors.New("service: failed to claim unsent messages")
+	ErrSendMessage      = errors.New("service: failed to send message")
+	ErrCreateMessage    = errors.New("service: failed to create message")
+	ErrInvalidRecipient = errors.New("service: invalid recipient")
+	ErrEmptyContent     = errors.New("service: empty content")
+	ErrContentTooLong   = errors.New("service: content too long")
+	ErrCreateMessages   = errors.New("service: failed to create messages")
+	ErrEmptyBatch       = errors.New("service: empty batch")
+	ErrBatchTooLarge    = errors.New("service: batch too large")
+	ErrListMessages     = errors.New("service: failed to list messages")
+	ErrGetMessage       = errors.New("service: failed to get message")
+	ErrMessageNotFound  = errors.New("service: message not found")
+	ErrCancelMessage    = errors.New("service: failed to cancel message")
+	ErrNotCancellable   = errors.New("service: message is no longer cancellable")
+	ErrRecordDelivery   = errors.New("service: failed to record delivery")
+	ErrLeaseLost        = errors.New("service: message lease lost")
+	ErrReleaseClaims    = errors.New("service: failed to release expired claims")
+	ErrRenewLease       = errors.New("service: failed to renew message lease")
+	ErrRecordPart       = errors.New("service: failed to record message part")
 )
 
+// MaxContentLength is the maximum number of characters accepted for a single
+// message, which keeps multipart messages to a reasonable number of parts.
+const MaxContentLength = 1600
+
+// MaxBatchSize is the maximum number of messages accepted by CreateMessages.
+const MaxBatchSize = 100000
+
+const (
+	// DefaultPageSize is used by ListMessages when the filter has no limit.
+	DefaultPageSize = 50
+	// MaxPageSize is the largest page ListMessages returns.
+	MaxPageSize = 500
+)
+
+// recipientPattern matches phone numbers in E.164 format.
+var recipientPattern = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
 //go:generate mockery --name=Service --output=../../../mock/service/message --outpkg=mock_service_message --case=underscore --with-expecter
 type Service interface {
-	GetUnsentMessages(ctx context.Context) ([]model.Message, error)
-	GetSentMessages(ctx context.Context) ([]model.Message, error)
-	UpdateMessage(ctx context.Context, id int, status model.MessageStatus) error
-	SendMessage(ctx context.Context, message MessageRequest) error
+	ClaimUnsentMessages(ctx context.Context, owner string, lease time.Duration, limit int) ([]model.Message, error)
+	ReleaseExpiredClaims(ctx context.Context) (int, error)
+	RenewLease(ctx context.Context, msg model.Message, lease time.Duration) error
+	SendMessage(ctx context.Context, message MessageRequest) (*SendResult, error)
+	MarkSent(ctx context.Context, msg model.Message, result SendResult) error
+	MarkFailed(ctx context.Context, msg model.Message, result SendResult, sendErr error) error
+	CreateMessage(ctx context.Context, message MessageRequest) (*model.Message, error)
+	CreateMessages(ctx context.Context, messages []MessageRequest) ([]BatchResult, error)
+	ListMessages(ctx context.Context, filter model.MessageFilter) (*MessagePage, error)
+	GetMessage(ctx context.Context, id int) (*model.Message, error)
+	CancelMessage(ctx context.Context, id int) error
 }
 
 type service struct {
-	repository repository.MessageRepository
-	driver     driver.MessageDriver
-	logger     *logrus.Logger
+	repository  repository.MessageRepository
+	driver      driver.MessageDriver
+	retryPolicy RetryPolicy
+	logger      *logrus.Logger
 }
 
-func New(repo repository.MessageRepository, driver driver.MessageDriver, logger *logrus.Logger) Service {
-	return &service{
-		repository: repo,
-		driver:     driver,
-		logger:     logger,
+func New(repo repository.MessageRepository, driver driver.MessageDriver, logger *logrus.Logger, opts ...Option) Service {
+	s := &service{
+		repository:  repo,
+		driver:      driver,
+		retryPolicy: DefaultRetryPolicy(),
+		logger:      logger,
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
-func (s *service) GetSentMessages(ctx context.Context) ([]model.Message, error) {
-	messages, err := s.repository.GetAll(ctx, model.StatusSent)
+// ClaimUnsentMessages leases the messages that are due for dispatch to owner:
+// pending messages and failed messages whose retry backoff has elapsed.
+// Leased messages are not handed out to other owners until the lease expires.
+// At most limit messages are claimed, oldest first.
+func (s *service) ClaimUnsentMessages(ctx context.Context, owner string, lease time.Duration, limit int) ([]model.Message, error) {
+	messages, err := s.repository.Claim(ctx, owner, time.Now(), lease, limit)
 	if err != nil {
-		s.logger.WithError(err).Error(ErrGetSentMessages)
-		return nil, ErrGetSentMessages
+		s.logger.WithField("owner", owner).WithError(err).Error(ErrClaimMessages)
+		return nil, ErrClaimMessages
 	}
 
-	s.logger.WithField("count", len(messages)).Info("Fetched sent messages")
+	s.logger.WithFields(logrus.Fields{"count": len(messages), "owner": owner}).Info("Claimed unsent messages")
+
 	return messages, nil
 }
 
-func (s *service) GetUnsentMessages(ctx context.Context) ([]model.Message, error) {
-	messages, err := s.repository.GetAll(ctx, model.StatusPending, model.StatusFailed)
+// ReleaseExpiredClaims returns messages whose lease expired without a
+// recorded result to pending, so that another run picks them up again.
+// Messages that have used up their attempts are marked dead instead.
+func (s *service) ReleaseExpiredClaims(ctx context.Context) (int, error) {
+	released, err := s.repository.ReleaseExpired(ctx, time.Now(), s.retryPolicy.MaxAttempts)
 	if err != nil {
-		s.logger.WithError(err).Error(ErrGetUnsentMessages)
-		return nil, ErrGetUnsentMessages
+		s.logger.WithError(err).Error(ErrReleaseClaims)
+		return 0, ErrReleaseClaims
 	}
 
-	s.logger.WithField("count", len(messages)).Info("Fetched unsent messages")
+	if released > 0 {
+		s.logger.WithField("count", released).Warn("Released messages with expired leases")
+	}
 
-	return messages, nil
+	return released, nil
 }
 
-type MessageRequest struct {
-	Recipient string `json:"recipient"`
-	Content   string `json:"content"`
+// RenewLease extends the lease of a claimed message by lease from now, so
+// that it does not expire while the message is being sent. It returns
+// ErrLeaseLost if the message has been handed to another owner in the
+// meantime, in which case it must not be sent.
+func (s *service) RenewLease(ctx context.Context, msg model.Message, lease time.Duration) error {
+	err := s.repository.RenewLease(ctx, msg.ID, msg.LeaseOwner, time.Now().Add(lease))
+	if errors.Is(err, repository.ErrLeaseLost) {
+		s.logger.WithFields(logrus.Fields{"id": msg.ID, "owner": msg.LeaseOwner}).Warn(ErrLeaseLost)
+		return ErrLeaseLost
+	}
+	if err != nil {
+		s.logger.WithField("id", msg.ID).WithError(err).Error(ErrRenewLease)
+		return ErrRenewLease
+	}
+
+	return nil
+}
+
+// MessagePage is a single page of a message listing. NextCursor is zero when
+// there are no further pages.
+type MessagePage struct {
+	Messages   []model.Message
+	NextCursor int
 }
 
-func (s *service) UpdateMessage(ctx context.Context, id int, status model.MessageStatus) error {
-	err := s.repository.Update(ctx, id, status)
+// ListMessages returns the page of messages matching the filter. The filter
+// limit is clamped to MaxPageSize and defaults to DefaultPageSize.
+func (s *service) ListMessages(ctx context.Context, filter model.MessageFilter) (*MessagePage, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultPageSize
+	}
+	if filter.Limit > MaxPageSize {
+		filter.Limit = MaxPageSize
+	}
+	pageSize := filter.Limit
+
+	// Fetch one extra row to find out whether another page follows.
+	filter.Limit++
+	messages, err := s.repository.List(ctx, filter)
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{"id": id, "status": status}).WithError(err).Error(ErrUpdateMessage)
-		return ErrUpdateMessage
+		s.logger.WithError(err).Error(ErrListMessages)
+		return nil, ErrListMessages
 	}
 
-	s.logger.WithFields(logrus.Fields{"id": id, "status": status}).Info("Message status updated")
+	page := &MessagePage{Messages: messages}
+	if len(messages) > pageSize {
+		page.Messages = messages[:pageSize]
+		page.NextCursor = page.Messages[pageSize-1].ID
+	}
+
+	s.logger.WithField("count", len(page.Messages)).Info("Fetched messages")
+	return page, nil
+}
+
+func (s *service) GetMessage(ctx context.Context, id int) (*model.Message, error) {
+	msg, err := s.repository.GetByID(ctx, id)
+	if errors.Is(err, repository.ErrMessageNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		s.logger.WithField("id", id).WithError(err).Error(ErrGetMessage)
+		return nil, ErrGetMessage
+	}
+
+	return msg, nil
+}
+
+// CancelMessage withdraws a message that has not been dispatched yet so the
+// scheduler never picks it up.
+func (s *service) CancelMessage(ctx context.Context, id int) error {
+	err := s.repository.Cancel(ctx, id)
+	switch {
+	case errors.Is(err, repository.ErrMessageNotFound):
+		return ErrMessageNotFound
+	case errors.Is(err, repository.ErrMessageNotCancellable):
+		return ErrNotCancellable
+	case err != nil:
+		s.logger.WithField("id", id).WithError(err).Error(ErrCancelMessage)
+		return ErrCancelMessage
+	}
+
+	s.logger.WithField("id", id).Info("Message cancelled")
 	return nil
 }
 
-func (s *service) SendMessage(ctx context.Context, message MessageRequest) error {
+type MessageRequest struct {
+	Recipient string `json:"recipient"`
+	Content   string `json:"content"`
+
+	// SentParts lists the 1-based numbers of multipart parts delivered by
+	// an earlier attempt. They are not sent again.
+	SentParts []int `json:"-"`
+	// MessageID and LeaseOwner identify the claimed message being sent.
+	// When LeaseOwner is set, every multipart part is recorded as soon as
+	// the provider accepts it, as long as the message is still leased by
+	// LeaseOwner, and the lease is extended by Lease from then on.
+	MessageID  int           `json:"-"`
+	LeaseOwner string        `json:"-"`
+	Lease      time.Duration `json:"-"`
+}
+
+// BatchResult reports the outcome of a single item of a CreateMessages call.
+// Err is set to a validation error when the item was rejected.
+type BatchResult struct {
+	Index  int
+	ID     int
+	Status model.MessageStatus
+	Err    error
+}
+
+// SendResult describes a single attempt to send a message.
+type SendResult struct {
+	ProviderMessageID string
+	HTTPStatus        int
+	StartedAt         time.Time
+	FinishedAt        time.Time
+	// FailedPart is the 1-based number of the multipart part that failed,
+	// or 0.
+	FailedPart int
+	// Permanent reports that the attempt failed in a way that retrying will
+	// not fix, for example because the provider rejected the request.
+	Permanent bool
+	// RetryAfter is the wait the provider asked for before the next
+	// attempt, or 0.
+	RetryAfter time.Duration
+}
+
+// SendMessage sends the message through the driver. The returned result is
+// never nil and describes the attempt even when an error is returned, so that
+// failed attempts can be recorded as well. The returned error wraps both
+// ErrSendMessage and the driver error, so a *driver.ProviderError can be
+// retrieved with errors.As.
+func (s *service) SendMessage(ctx context.Context, message MessageRequest) (*SendResult, error) {
 	req := driver.MessageRequest{
 		Recipient: message.Recipient,
 		Content:   message.Content,
+		SkipParts: message.SentParts,
+	}
+	if message.LeaseOwner != "" {
+		req.OnPart = func(ctx context.Context, part driver.PartResponse) error {
+			return s.recordPart(ctx, message, part)
+		}
 	}
 
-	_, err := s.driver.Send(ctx, req)
+	result := &SendResult{StartedAt: time.Now()}
+	resp, err := s.driver.Send(ctx, req)
+	result.FinishedAt = time.Now()
 	if err != nil {
-		s.logger.WithFields(logrus.Fields{"recipient": message.Recipient}).WithError(err).Error(ErrSendMessage)
-		return ErrSendMessage
+		var partial *driver.PartialSendError
+		if errors.As(err, &partial) {
+			result.FailedPart = partial.Part
+		}
+		var providerErr *driver.ProviderError
+		if errors.As(err, &providerErr) {
+			result.HTTPStatus = providerErr.StatusCode
+			result.RetryAfter = providerErr.RetryAfter
+		}
+		result.Permanent = driver.IsPermanent(err)
+
+		s.logger.WithFields(logrus.Fields{"recipient": message.Recipient, "permanent": result.Permanent}).WithError(err).Error(ErrSendMessage)
+		return result, fmt.Errorf("%w: %w", ErrSendMessage, err)
 	}
 
+	result.ProviderMessageID = resp.MessageID
+	result.HTTPStatus = resp.StatusCode
+
 	s.logger.WithFields(logrus.Fields{"recipient": message.Recipient}).Info("Message sent successfully")
+	return result, nil
+}
+
+// recordPart stores a multipart part the provider accepted for the claimed
+// message and renews its lease before the next part is sent. It returns
+// ErrLeaseLost if the message has been handed to another owner, in which case
+// the remaining parts must not be sent.
+func (s *service) recordPart(ctx context.Context, message MessageRequest, part driver.PartResponse) error {
+	now := time.Now()
+	err := s.repository.RecordPart(ctx, message.MessageID, message.LeaseOwner, model.MessagePart{
+		PartIndex:         part.Number,
+		ProviderMessageID: part.MessageID,
+		Content:           part.Content,
+		Encoding:          string(part.Encoding),
+		SentAt:            now,
+	}, now.Add(message.Lease))
+	if errors.Is(err, repository.ErrLeaseLost) {
+		s.logger.WithFields(logrus.Fields{"id": message.MessageID, "owner": message.LeaseOwner}).Warn(ErrLeaseLost)
+		return ErrLeaseLost
+	}
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{"id": message.MessageID, "part": part.Number}).WithError(err).Error(ErrRecordPart)
+		return ErrRecordPart
+	}
+
+	return nil
+}
+
+// MarkSent records a successful delivery of the message.
+func (s *service) MarkSent(ctx context.Context, msg model.Message, result SendResult) error {
+	return s.recordDelivery(ctx, msg.ID, model.Delivery{
+		LeaseOwner:        msg.LeaseOwner,
+		Status:            model.StatusSent,
+		ProviderMessageID: result.ProviderMessageID,
+		HTTPStatus:        result.HTTPStatus,
+		StartedAt:         result.StartedAt,
+		FinishedAt:        result.FinishedAt,
+	})
+}
+
+// MarkFailed records a failed delivery attempt of the message together with
+// the error that caused it. The message is scheduled for a retry with
+// exponential backoff, or later if the provider asked for it with
+// Retry-After. It is marked dead once it has used up its attempts or the
+// failure is permanent.
+func (s *service) MarkFailed(ctx context.Context, msg model.Message, result SendResult, sendErr error) error {
+	delivery := model.Delivery{
+		LeaseOwner: msg.LeaseOwner,
+		Status:     model.StatusFailed,
+		HTTPStatus: result.HTTPStatus,
+		Error:      sendErr.Error(),
+		StartedAt:  result.StartedAt,
+		FinishedAt: result.FinishedAt,
+		PartIndex:  result.FailedPart,
+	}
+
+	attempt := msg.Attempts + 1
+	switch {
+	case result.Permanent:
+		delivery.Status = model.StatusDead
+		s.logger.WithFields(logrus.Fields{"id": msg.ID, "attempts": attempt}).Warn("Message failed permanently and is marked dead")
+	case attempt >= s.retryPolicy.MaxAttempts:
+		delivery.Status = model.StatusDead
+		s.logger.WithFields(logrus.Fields{"id": msg.ID, "attempts": attempt}).Warn("Message exhausted its attempts and is marked dead")
+	default:
+		// The provider may ask for a longer wait than the backoff.
+		nextAttemptAt := result.FinishedAt.Add(max(s.retryPolicy.Backoff(attempt), result.RetryAfter))
+		delivery.NextAttemptAt = &nextAttemptAt
+	}
+
+	return s.recordDelivery(ctx, msg.ID, delivery)
+}
+
+func (s *service) recordDelivery(ctx context.Context, id int, delivery model.Delivery) error {
+	err := s.repository.RecordDelivery(ctx, id, delivery)
+	if errors.Is(err, repository.ErrLeaseLost) {
+		s.logger.WithFields(logrus.Fields{"id": id, "owner": delivery.LeaseOwner}).Warn(ErrLeaseLost)
+		return ErrLeaseLost
+	}
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{"id": id, "status": delivery.Status}).WithError(err).Error(ErrRecordDelivery)
+		return ErrRecordDelivery
+	}
+
+	s.logger.WithFields(logrus.Fields{"id": id, "status": delivery.Status}).Info("Message delivery recorded")
+	return nil
+}
+
+func (s *service) CreateMessage(ctx context.Context, message MessageRequest) (*model.Message, error) {
+	message.Recipient = strings.TrimSpace(message.Recipient)
+
+	if err := validateMessageRequest(message); err != nil {
+		s.logger.WithFields(logrus.Fields{"recipient": message.Recipient}).WithError(err).Warn("Rejected message request")
+		return nil, err
+	}
+
+	msg := model.Message{
+		Recipient: message.Recipient,
+		Content:   message.Content,
+		Status:    model.StatusPending,
+	}
+
+	if err := s.repository.Create(ctx, &msg); err != nil {
+		s.logger.WithFields(logrus.Fields{"recipient": message.Recipient}).WithError(err).Error(ErrCreateMessage)
+		return nil, ErrCreateMessage
+	}
+
+	s.logger.WithFields(logrus.Fields{"id": msg.ID, "recipient": msg.Recipient}).Info("Message created")
+	return &msg, nil
+}
+
+// CreateMessages validates every item independently and stores the valid ones
+// as pending in a single transaction. Invalid items are reported in the
+// returned results instead of failing the whole batch.
+func (s *service) CreateMessages(ctx context.Context, messages []MessageRequest) ([]BatchResult, error) {
+	if len(messages) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(messages) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]BatchResult, len(messages))
+	accepted := make([]model.Message, 0, len(messages))
+	acceptedIndexes := make([]int, 0, len(messages))
+
+	for i, message := range messages {
+		message.Recipient = strings.TrimSpace(message.Recipient)
+		results[i].Index = i
+
+		if err := validateMessageRequest(message); err != nil {
+			results[i].Err = err
+			continue
+		}
+
+		accepted = append(accepted, model.Message{
+			Recipient: message.Recipient,
+			Content:   message.Content,
+			Status:    model.StatusPending,
+		})
+		acceptedIndexes = append(acceptedIndexes, i)
+	}
+
+	if len(accepted) > 0 {
+		if err := s.repository.CreateMany(ctx, accepted); err != nil {
+			s.logger.WithField("count", len(accepted)).WithError(err).Error(ErrCreateMessages)
+			return nil, ErrCreateMessages
+		}
+	}
+
+	for i, msg := range accepted {
+		results[acceptedIndexes[i]].ID = msg.ID
+		results[acceptedIndexes[i]].Status = msg.Status
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"accepted": len(accepted),
+		"rejected": len(messages) - len(accepted),
+	}).Info("Message batch created")
+
+	return results, nil
+}
+
+// validateMessageRequest checks that the request can be dispatched as-is.
+func validateMessageRequest(message MessageRequest) error {
+	if !recipientPattern.MatchString(message.Recipient) {
+		return ErrInvalidRecipient
+	}
+
+	if strings.TrimSpace(message.Content) == "" {
+		return ErrEmptyContent
+	}
+
+	if utf8.RuneCountInString(message.Content) > MaxContentLength {
+		return ErrContentTooLong
+	}
+
 	return nil
 }
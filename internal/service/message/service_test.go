@@ -3,41 +3,46 @@ package message
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ecoderat/dispatch-go/internal/driver"
 	"github.com/ecoderat/dispatch-go/internal/model"
+	"github.com/ecoderat/dispatch-go/internal/repository"
+	"github.com/ecoderat/dispatch-go/internal/segment"
 	mockdriver "github.com/ecoderat/dispatch-go/mock/driver"
 	mockrepo "github.com/ecoderat/dispatch-go/mock/repository"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
-func TestService_GetUnsentMessages_Success(t *testing.T) {
+func TestService_ClaimUnsentMessages_Success(t *testing.T) {
 	repo := mockrepo.NewMessageRepository(t)
 	drv := mockdriver.NewMessageDriver(t)
 	logger := &logrus.Logger{}
 	svc := New(repo, drv, logger)
 
 	ctx := context.Background()
-	messages := []model.Message{{ID: 1, Recipient: "+123", Content: "hi", Status: "pending"}}
-	repo.EXPECT().GetAll(ctx, model.StatusPending, model.StatusFailed).Return(messages, nil)
+	messages := []model.Message{{ID: 1, Recipient: "+123", Content: "hi", Status: "processing", LeaseOwner: "node-a"}}
+	repo.EXPECT().Claim(ctx, "node-a", mock.AnythingOfType("time.Time"), time.Minute, 100).Return(messages, nil)
 
-	msgs, err := svc.GetUnsentMessages(ctx)
+	msgs, err := svc.ClaimUnsentMessages(ctx, "node-a", time.Minute, 100)
 	assert.NoError(t, err)
 	assert.Equal(t, messages, msgs)
 }
 
-func TestService_GetUnsentMessages_Fails(t *testing.T) {
+func TestService_ClaimUnsentMessages_Fails(t *testing.T) {
 	repo := mockrepo.NewMessageRepository(t)
 	drv := mockdriver.NewMessageDriver(t)
 	logger := &logrus.Logger{}
 	svc := New(repo, drv, logger)
 
 	ctx := context.Background()
-	repo.EXPECT().GetAll(ctx, model.StatusPending, model.StatusFailed).Return(nil, errors.New("db error"))
+	repo.EXPECT().Claim(ctx, "node-a", mock.AnythingOfType("time.Time"), time.Minute, 100).Return(nil, errors.New("db error"))
 
-	msgs, err := svc.GetUnsentMessages(ctx)
+	msgs, err := svc.ClaimUnsentMessages(ctx, "node-a", time.Minute, 100)
 	assert.Error(t, err)
 	assert.Nil(t, msgs)
 }
@@ -51,9 +56,69 @@ func TestService_SendMessage_Success(t *testing.T) {
 	ctx := context.Background()
 	msgReq := MessageRequest{Recipient: "+123", Content: "hi"}
 	drv.EXPECT().Send(ctx, driver.MessageRequest{Recipient: "+123", Content: "hi"}).
-		Return(&driver.MessageResponse{Message: "ok", MessageID: "123"}, nil)
-	err := svc.SendMessage(ctx, msgReq)
+		Return(&driver.MessageResponse{Message: "ok", MessageID: "123", StatusCode: 202}, nil)
+	result, err := svc.SendMessage(ctx, msgReq)
 	assert.NoError(t, err)
+	assert.Equal(t, "123", result.ProviderMessageID)
+	assert.Equal(t, 202, result.HTTPStatus)
+	assert.False(t, result.StartedAt.IsZero())
+	assert.False(t, result.FinishedAt.Before(result.StartedAt))
+}
+
+func TestService_SendMessage_Multipart(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	parts := []driver.PartResponse{
+		{Number: 1, MessageID: "p-1", Content: "Şif [1/2]", Encoding: segment.UCS2},
+		{Number: 2, MessageID: "p-2", Content: "re [2/2]", Encoding: segment.UCS2},
+	}
+	msgReq := MessageRequest{Recipient: "+123", Content: "Şifre", MessageID: 1, LeaseOwner: "node-a", Lease: time.Minute}
+	drv.EXPECT().Send(ctx, mock.MatchedBy(func(req driver.MessageRequest) bool {
+		return req.Recipient == "+123" && req.Content == "Şifre" && req.OnPart != nil
+	})).RunAndReturn(func(ctx context.Context, req driver.MessageRequest) (*driver.MessageResponse, error) {
+		for _, part := range parts {
+			if err := req.OnPart(ctx, part); err != nil {
+				return nil, err
+			}
+		}
+		return &driver.MessageResponse{Message: "ok", MessageID: "p-2", StatusCode: 202, Parts: parts}, nil
+	})
+	for _, part := range parts {
+		repo.EXPECT().RecordPart(ctx, 1, "node-a", mock.MatchedBy(func(got model.MessagePart) bool {
+			return got.PartIndex == part.Number && got.ProviderMessageID == part.MessageID &&
+				got.Content == part.Content && got.Encoding == "ucs2" && !got.SentAt.IsZero()
+		}), mock.MatchedBy(func(expiresAt time.Time) bool {
+			return time.Until(expiresAt) > 50*time.Second
+		})).Return(nil).Once()
+	}
+
+	result, err := svc.SendMessage(ctx, msgReq)
+	assert.NoError(t, err)
+	assert.Equal(t, "p-2", result.ProviderMessageID)
+}
+
+func TestService_SendMessage_MultipartLeaseLost(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	part := driver.PartResponse{Number: 1, MessageID: "p-1", Content: "Şif [1/2]", Encoding: segment.UCS2}
+	drv.EXPECT().Send(ctx, mock.Anything).
+		RunAndReturn(func(ctx context.Context, req driver.MessageRequest) (*driver.MessageResponse, error) {
+			err := req.OnPart(ctx, part)
+			return nil, &driver.PartialSendError{Part: 1, Sent: []driver.PartResponse{part}, Err: err}
+		})
+	repo.EXPECT().RecordPart(ctx, 1, "node-a", mock.Anything, mock.Anything).Return(repository.ErrLeaseLost)
+
+	result, err := svc.SendMessage(ctx, MessageRequest{Recipient: "+123", Content: "Şifre", MessageID: 1, LeaseOwner: "node-a"})
+	assert.ErrorIs(t, err, ErrLeaseLost)
+	assert.Equal(t, 1, result.FailedPart)
 }
 
 func TestService_SendMessage_Fails(t *testing.T) {
@@ -67,61 +132,510 @@ func TestService_SendMessage_Fails(t *testing.T) {
 	drv.EXPECT().Send(ctx, driver.MessageRequest{Recipient: "+123", Content: "hi"}).
 		Return(nil, errors.New("send error"))
 
-	err := svc.SendMessage(ctx, msgReq)
-	assert.Error(t, err)
+	result, err := svc.SendMessage(ctx, msgReq)
+	assert.ErrorIs(t, err, ErrSendMessage)
+	assert.ErrorContains(t, err, "send error")
+	assert.NotNil(t, result)
+	assert.Empty(t, result.ProviderMessageID)
 }
 
-func TestService_GetSentMessages_Success(t *testing.T) {
+func TestService_SendMessage_ProviderError(t *testing.T) {
 	repo := mockrepo.NewMessageRepository(t)
 	drv := mockdriver.NewMessageDriver(t)
 	logger := &logrus.Logger{}
 	svc := New(repo, drv, logger)
 
 	ctx := context.Background()
-	messages := []model.Message{{ID: 1, Recipient: "+123", Content: "hi", Status: "sent"}}
-	repo.EXPECT().GetAll(ctx, model.StatusSent).Return(messages, nil)
+	providerErr := &driver.ProviderError{StatusCode: 400, Body: `{"error":"invalid number"}`, Reason: driver.ReasonInvalidRequest, RetryAfter: time.Minute}
+	drv.EXPECT().Send(ctx, driver.MessageRequest{Recipient: "+123", Content: "hi"}).Return(nil, providerErr)
 
-	msgs, err := svc.GetSentMessages(ctx)
+	result, err := svc.SendMessage(ctx, MessageRequest{Recipient: "+123", Content: "hi"})
+	assert.ErrorIs(t, err, ErrSendMessage)
+	assert.ErrorIs(t, err, driver.ErrUnexpectedStatus)
+	assert.ErrorContains(t, err, "invalid number")
+
+	var got *driver.ProviderError
+	assert.ErrorAs(t, err, &got)
+	assert.Same(t, providerErr, got)
+	assert.Equal(t, 400, result.HTTPStatus)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+	assert.True(t, result.Permanent)
+}
+
+func TestService_SendMessage_PartialFailure(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	msgReq := MessageRequest{Recipient: "+123", Content: "long", SentParts: []int{1}}
+	drv.EXPECT().Send(ctx, driver.MessageRequest{Recipient: "+123", Content: "long", SkipParts: []int{1}}).
+		Return(nil, &driver.PartialSendError{Part: 3, Sent: []driver.PartResponse{{Number: 2, MessageID: "p-2", Content: "ng [2/3]", Encoding: segment.GSM7}}, Err: errors.New("send error")})
+
+	result, err := svc.SendMessage(ctx, msgReq)
+	assert.ErrorIs(t, err, ErrSendMessage)
+	assert.ErrorContains(t, err, "part 3: send error")
+	assert.Equal(t, 3, result.FailedPart)
+}
+
+func TestService_CreateMessage_Success(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().Create(ctx, &model.Message{Recipient: "+905551112233", Content: "hi", Status: model.StatusPending}).
+		Run(func(_ context.Context, message *model.Message) { message.ID = 42 }).
+		Return(nil)
+
+	msg, err := svc.CreateMessage(ctx, MessageRequest{Recipient: " +905551112233 ", Content: "hi"})
 	assert.NoError(t, err)
-	assert.Equal(t, messages, msgs)
+	assert.Equal(t, 42, msg.ID)
+	assert.Equal(t, model.StatusPending, msg.Status)
 }
 
-func TestService_GetSentMessages_Fails(t *testing.T) {
+func TestService_CreateMessage_Invalid(t *testing.T) {
 	repo := mockrepo.NewMessageRepository(t)
 	drv := mockdriver.NewMessageDriver(t)
 	logger := &logrus.Logger{}
 	svc := New(repo, drv, logger)
 
 	ctx := context.Background()
-	repo.EXPECT().GetAll(ctx, model.StatusSent).Return(nil, errors.New("db error"))
+	tests := []struct {
+		name string
+		req  MessageRequest
+		err  error
+	}{
+		{name: "missing recipient", req: MessageRequest{Content: "hi"}, err: ErrInvalidRecipient},
+		{name: "malformed recipient", req: MessageRequest{Recipient: "0555 111", Content: "hi"}, err: ErrInvalidRecipient},
+		{name: "empty content", req: MessageRequest{Recipient: "+905551112233", Content: "  "}, err: ErrEmptyContent},
+		{name: "content too long", req: MessageRequest{Recipient: "+905551112233", Content: strings.Repeat("a", MaxContentLength+1)}, err: ErrContentTooLong},
+	}
 
-	msgs, err := svc.GetSentMessages(ctx)
-	assert.Error(t, err)
-	assert.Nil(t, msgs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := svc.CreateMessage(ctx, tt.req)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Nil(t, msg)
+		})
+	}
+}
+
+func TestService_CreateMessage_Fails(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().Create(ctx, &model.Message{Recipient: "+905551112233", Content: "hi", Status: model.StatusPending}).
+		Return(errors.New("db error"))
+
+	msg, err := svc.CreateMessage(ctx, MessageRequest{Recipient: "+905551112233", Content: "hi"})
+	assert.ErrorIs(t, err, ErrCreateMessage)
+	assert.Nil(t, msg)
+}
+
+func TestService_CreateMessages_PartiallyValid(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().CreateMany(ctx, []model.Message{
+		{Recipient: "+905551112233", Content: "first", Status: model.StatusPending},
+		{Recipient: "+905551112244", Content: "third", Status: model.StatusPending},
+	}).Run(func(_ context.Context, messages []model.Message) {
+		messages[0].ID = 10
+		messages[1].ID = 11
+	}).Return(nil)
+
+	results, err := svc.CreateMessages(ctx, []MessageRequest{
+		{Recipient: "+905551112233", Content: "first"},
+		{Recipient: "not-a-number", Content: "second"},
+		{Recipient: "+905551112244", Content: "third"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, BatchResult{Index: 0, ID: 10, Status: model.StatusPending}, results[0])
+	assert.Equal(t, 1, results[1].Index)
+	assert.ErrorIs(t, results[1].Err, ErrInvalidRecipient)
+	assert.Equal(t, BatchResult{Index: 2, ID: 11, Status: model.StatusPending}, results[2])
 }
 
-func TestService_UpdateMessage_Success(t *testing.T) {
+func TestService_CreateMessages_AllInvalid(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	results, err := svc.CreateMessages(context.Background(), []MessageRequest{{Recipient: "+905551112233"}})
+	assert.NoError(t, err)
+	assert.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrEmptyContent)
+}
+
+func TestService_CreateMessages_EmptyBatch(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	results, err := svc.CreateMessages(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrEmptyBatch)
+	assert.Nil(t, results)
+}
+
+func TestService_CreateMessages_Fails(t *testing.T) {
 	repo := mockrepo.NewMessageRepository(t)
 	drv := mockdriver.NewMessageDriver(t)
 	logger := &logrus.Logger{}
 	svc := New(repo, drv, logger)
 
 	ctx := context.Background()
-	repo.EXPECT().Update(ctx, 1, model.StatusSent).Return(nil)
+	repo.EXPECT().CreateMany(ctx, []model.Message{
+		{Recipient: "+905551112233", Content: "hi", Status: model.StatusPending},
+	}).Return(errors.New("db error"))
 
-	err := svc.UpdateMessage(ctx, 1, model.StatusSent)
+	results, err := svc.CreateMessages(ctx, []MessageRequest{{Recipient: "+905551112233", Content: "hi"}})
+	assert.ErrorIs(t, err, ErrCreateMessages)
+	assert.Nil(t, results)
+}
+
+func TestService_ListMessages_NextPage(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	messages := []model.Message{{ID: 4}, {ID: 5}, {ID: 6}}
+	repo.EXPECT().List(ctx, model.MessageFilter{Statuses: []model.MessageStatus{model.StatusSent}, AfterID: 3, Limit: 3}).
+		Return(messages, nil)
+
+	page, err := svc.ListMessages(ctx, model.MessageFilter{Statuses: []model.MessageStatus{model.StatusSent}, AfterID: 3, Limit: 2})
 	assert.NoError(t, err)
+	assert.Equal(t, messages[:2], page.Messages)
+	assert.Equal(t, 5, page.NextCursor)
 }
 
-func TestService_UpdateMessage_Fails(t *testing.T) {
+func TestService_ListMessages_LastPage(t *testing.T) {
 	repo := mockrepo.NewMessageRepository(t)
 	drv := mockdriver.NewMessageDriver(t)
 	logger := &logrus.Logger{}
 	svc := New(repo, drv, logger)
 
 	ctx := context.Background()
-	repo.EXPECT().Update(ctx, 1, model.StatusSent).Return(errors.New("update error"))
+	messages := []model.Message{{ID: 1}}
+	repo.EXPECT().List(ctx, model.MessageFilter{Limit: DefaultPageSize + 1}).Return(messages, nil)
 
-	err := svc.UpdateMessage(ctx, 1, model.StatusSent)
-	assert.Error(t, err)
+	page, err := svc.ListMessages(ctx, model.MessageFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, messages, page.Messages)
+	assert.Zero(t, page.NextCursor)
+}
+
+func TestService_ListMessages_Fails(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().List(ctx, model.MessageFilter{Limit: MaxPageSize + 1}).Return(nil, errors.New("db error"))
+
+	page, err := svc.ListMessages(ctx, model.MessageFilter{Limit: 10000})
+	assert.ErrorIs(t, err, ErrListMessages)
+	assert.Nil(t, page)
+}
+
+func TestService_GetMessage_Success(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	message := &model.Message{ID: 1, Recipient: "+123", Content: "hi", Status: "sent"}
+	repo.EXPECT().GetByID(ctx, 1).Return(message, nil)
+
+	msg, err := svc.GetMessage(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, message, msg)
+}
+
+func TestService_GetMessage_NotFound(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().GetByID(ctx, 1).Return(nil, repository.ErrMessageNotFound)
+
+	msg, err := svc.GetMessage(ctx, 1)
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+	assert.Nil(t, msg)
+}
+
+func TestService_GetMessage_Fails(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().GetByID(ctx, 1).Return(nil, errors.New("db error"))
+
+	msg, err := svc.GetMessage(ctx, 1)
+	assert.ErrorIs(t, err, ErrGetMessage)
+	assert.Nil(t, msg)
+}
+
+func TestService_CancelMessage_Success(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().Cancel(ctx, 1).Return(nil)
+
+	err := svc.CancelMessage(ctx, 1)
+	assert.NoError(t, err)
+}
+
+func TestService_CancelMessage_Fails(t *testing.T) {
+	tests := []struct {
+		name    string
+		repoErr error
+		err     error
+	}{
+		{name: "not found", repoErr: repository.ErrMessageNotFound, err: ErrMessageNotFound},
+		{name: "not cancellable", repoErr: repository.ErrMessageNotCancellable, err: ErrNotCancellable},
+		{name: "db error", repoErr: errors.New("db error"), err: ErrCancelMessage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := mockrepo.NewMessageRepository(t)
+			drv := mockdriver.NewMessageDriver(t)
+			logger := &logrus.Logger{}
+			svc := New(repo, drv, logger)
+
+			ctx := context.Background()
+			repo.EXPECT().Cancel(ctx, 1).Return(tt.repoErr)
+
+			err := svc.CancelMessage(ctx, 1)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestService_MarkSent_Success(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	startedAt := time.Now()
+	result := SendResult{ProviderMessageID: "prov-1", HTTPStatus: 202, StartedAt: startedAt, FinishedAt: startedAt.Add(time.Second)}
+	repo.EXPECT().RecordDelivery(ctx, 1, model.Delivery{
+		LeaseOwner:        "node-a",
+		Status:            model.StatusSent,
+		ProviderMessageID: "prov-1",
+		HTTPStatus:        202,
+		StartedAt:         result.StartedAt,
+		FinishedAt:        result.FinishedAt,
+	}).Return(nil)
+
+	err := svc.MarkSent(ctx, model.Message{ID: 1, LeaseOwner: "node-a"}, result)
+	assert.NoError(t, err)
+}
+
+func TestService_MarkFailed_SchedulesRetry(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}))
+
+	ctx := context.Background()
+	finishedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	nextAttemptAt := finishedAt.Add(2 * time.Minute)
+	repo.EXPECT().RecordDelivery(ctx, 1, model.Delivery{
+		LeaseOwner:    "node-a",
+		Status:        model.StatusFailed,
+		Error:         "send error",
+		FinishedAt:    finishedAt,
+		NextAttemptAt: &nextAttemptAt,
+	}).Return(nil)
+
+	err := svc.MarkFailed(ctx, model.Message{ID: 1, Attempts: 1, LeaseOwner: "node-a"}, SendResult{FinishedAt: finishedAt}, errors.New("send error"))
+	assert.NoError(t, err)
+}
+
+func TestService_MarkFailed_RecordsFailedPart(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}))
+
+	ctx := context.Background()
+	finishedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	nextAttemptAt := finishedAt.Add(time.Minute)
+	repo.EXPECT().RecordDelivery(ctx, 1, model.Delivery{
+		LeaseOwner:    "node-a",
+		Status:        model.StatusFailed,
+		Error:         "part 2: send error",
+		FinishedAt:    finishedAt,
+		NextAttemptAt: &nextAttemptAt,
+		PartIndex:     2,
+	}).Return(nil)
+
+	result := SendResult{FinishedAt: finishedAt, FailedPart: 2}
+	err := svc.MarkFailed(ctx, model.Message{ID: 1, LeaseOwner: "node-a"}, result, errors.New("part 2: send error"))
+	assert.NoError(t, err)
+}
+
+func TestService_MarkFailed_HonorsRetryAfter(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}))
+
+	ctx := context.Background()
+	finishedAt := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	nextAttemptAt := finishedAt.Add(time.Hour)
+	repo.EXPECT().RecordDelivery(ctx, 1, model.Delivery{
+		LeaseOwner:    "node-a",
+		Status:        model.StatusFailed,
+		HTTPStatus:    429,
+		Error:         "rate limited",
+		FinishedAt:    finishedAt,
+		NextAttemptAt: &nextAttemptAt,
+	}).Return(nil)
+
+	result := SendResult{HTTPStatus: 429, FinishedAt: finishedAt, RetryAfter: time.Hour}
+	err := svc.MarkFailed(ctx, model.Message{ID: 1, LeaseOwner: "node-a"}, result, errors.New("rate limited"))
+	assert.NoError(t, err)
+}
+
+func TestService_MarkFailed_MarksDead(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}))
+
+	ctx := context.Background()
+	repo.EXPECT().RecordDelivery(ctx, 1, model.Delivery{
+		Status: model.StatusDead,
+		Error:  "send error",
+	}).Return(nil)
+
+	err := svc.MarkFailed(ctx, model.Message{ID: 1, Attempts: 2}, SendResult{}, errors.New("send error"))
+	assert.NoError(t, err)
+}
+
+func TestService_MarkFailed_PermanentMarksDead(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger, WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}))
+
+	ctx := context.Background()
+	repo.EXPECT().RecordDelivery(ctx, 1, model.Delivery{
+		Status:     model.StatusDead,
+		HTTPStatus: 400,
+		Error:      "invalid recipient",
+	}).Return(nil)
+
+	err := svc.MarkFailed(ctx, model.Message{ID: 1}, SendResult{HTTPStatus: 400, Permanent: true}, errors.New("invalid recipient"))
+	assert.NoError(t, err)
+}
+
+func TestService_MarkSent_LeaseLost(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().RecordDelivery(ctx, 1, mock.Anything).Return(repository.ErrLeaseLost)
+
+	err := svc.MarkSent(ctx, model.Message{ID: 1, LeaseOwner: "node-a"}, SendResult{})
+	assert.ErrorIs(t, err, ErrLeaseLost)
+}
+
+func TestService_MarkFailed_Fails(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().RecordDelivery(ctx, 1, mock.Anything).Return(errors.New("db error"))
+
+	err := svc.MarkFailed(ctx, model.Message{ID: 1}, SendResult{}, errors.New("send error"))
+	assert.ErrorIs(t, err, ErrRecordDelivery)
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Minute, MaxDelay: 10 * time.Minute}
+
+	assert.Equal(t, time.Minute, policy.Backoff(1))
+	assert.Equal(t, 2*time.Minute, policy.Backoff(2))
+	assert.Equal(t, 8*time.Minute, policy.Backoff(4))
+	assert.Equal(t, 10*time.Minute, policy.Backoff(5))
+	assert.Equal(t, 10*time.Minute, policy.Backoff(50))
+}
+
+func TestService_RenewLease(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	before := time.Now()
+	repo.EXPECT().RenewLease(ctx, 1, "node-a", mock.MatchedBy(func(expiresAt time.Time) bool {
+		return !expiresAt.Before(before.Add(time.Minute))
+	})).Return(nil).Once()
+	repo.EXPECT().RenewLease(ctx, 2, "node-a", mock.Anything).Return(repository.ErrLeaseLost).Once()
+	repo.EXPECT().RenewLease(ctx, 3, "node-a", mock.Anything).Return(errors.New("db error")).Once()
+
+	assert.NoError(t, svc.RenewLease(ctx, model.Message{ID: 1, LeaseOwner: "node-a"}, time.Minute))
+	assert.ErrorIs(t, svc.RenewLease(ctx, model.Message{ID: 2, LeaseOwner: "node-a"}, time.Minute), ErrLeaseLost)
+	assert.ErrorIs(t, svc.RenewLease(ctx, model.Message{ID: 3, LeaseOwner: "node-a"}, time.Minute), ErrRenewLease)
+}
+
+func TestService_ReleaseExpiredClaims_Success(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().ReleaseExpired(ctx, mock.AnythingOfType("time.Time"), DefaultRetryPolicy().MaxAttempts).Return(2, nil)
+
+	released, err := svc.ReleaseExpiredClaims(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, released)
+}
+
+func TestService_ReleaseExpiredClaims_Fails(t *testing.T) {
+	repo := mockrepo.NewMessageRepository(t)
+	drv := mockdriver.NewMessageDriver(t)
+	logger := &logrus.Logger{}
+	svc := New(repo, drv, logger)
+
+	ctx := context.Background()
+	repo.EXPECT().ReleaseExpired(ctx, mock.AnythingOfType("time.Time"), DefaultRetryPolicy().MaxAttempts).Return(0, errors.New("db error"))
+
+	released, err := svc.ReleaseExpiredClaims(ctx)
+	assert.ErrorIs(t, err, ErrReleaseClaims)
+	assert.Zero(t, released)
 }
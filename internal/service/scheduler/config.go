@@ -0,0 +1,126 @@
+package scheduler
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidInterval    = errors.New("scheduler: invalid interval")
+	ErrInvalidBatchSize   = errors.New("scheduler: invalid batch size")
+	ErrInvalidConcurrency = errors.New("scheduler: invalid concurrency")
+)
+
+// Config configures a scheduler.
+type Config struct {
+	// InstanceID identifies this dispatcher instance. Messages are claimed
+	// under this ID so that several instances can share the same database.
+	InstanceID string
+	// Interval is the time between two scheduled runs.
+	Interval time.Duration
+	// LeaseDuration is how long claimed messages stay reserved for this
+	// instance. Messages whose result is not recorded within the lease, for
+	// example because the process died, are returned to pending.
+	LeaseDuration time.Duration
+	// BatchSize is the maximum number of messages claimed and held in
+	// memory at once.
+	BatchSize int
+	// TickBudget bounds how long a single run keeps claiming batches. Once
+	// it is used up, the remaining backlog is left for the next run.
+	TickBudget time.Duration
+	// Concurrency is the number of messages sent in parallel.
+	Concurrency int
+	// Debounce is how long the scheduler waits after Wake before it runs,
+	// so that a burst of wake-ups results in a single run.
+	Debounce time.Duration
+	// Leader returns the instance ID of the current leader when the
+	// scheduler only runs on the elected leader. It is nil otherwise.
+	Leader func() string
+	// IsLeader reports whether this instance is the elected leader. When it
+	// is set, Start and RunNow fail with ErrNotLeader on other instances.
+	IsLeader func() bool
+}
+
+const (
+	// DefaultInterval is used when Config.Interval is not set.
+	DefaultInterval = 2 * time.Minute
+	// DefaultLeaseDuration is used when Config.LeaseDuration is not set.
+	DefaultLeaseDuration = 5 * time.Minute
+	// DefaultBatchSize is used when Config.BatchSize is not set.
+	DefaultBatchSize = 100
+	// DefaultTickBudget is used when Config.TickBudget is not set.
+	DefaultTickBudget = time.Minute
+	// DefaultConcurrency is used when Config.Concurrency is not set.
+	DefaultConcurrency = 4
+	// DefaultDebounce is used when Config.Debounce is not set.
+	DefaultDebounce = 500 * time.Millisecond
+)
+
+const (
+	// MinInterval is the shortest interval accepted by UpdateConfig.
+	MinInterval = time.Second
+	// MaxBatchSize is the largest batch size accepted by UpdateConfig.
+	MaxBatchSize = 10000
+	// MaxConcurrency is the largest concurrency accepted by UpdateConfig.
+	MaxConcurrency = 100
+)
+
+// ConfigUpdate changes the scheduler configuration at runtime. Nil fields
+// are left unchanged.
+type ConfigUpdate struct {
+	Interval    *time.Duration
+	BatchSize   *int
+	Concurrency *int
+}
+
+func (c Config) withDefaults() Config {
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.LeaseDuration <= 0 {
+		c.LeaseDuration = DefaultLeaseDuration
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = DefaultBatchSize
+	}
+	if c.TickBudget <= 0 {
+		c.TickBudget = DefaultTickBudget
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = DefaultConcurrency
+	}
+	if c.Debounce <= 0 {
+		c.Debounce = DefaultDebounce
+	}
+	return c
+}
+
+// apply returns c with the update applied, or an error if any updated value
+// is out of range.
+func (c Config) apply(update ConfigUpdate) (Config, error) {
+	if update.Interval != nil {
+		if *update.Interval < MinInterval {
+			return c, ErrInvalidInterval
+		}
+		c.Interval = *update.Interval
+	}
+	if update.BatchSize != nil {
+		if *update.BatchSize <= 0 || *update.BatchSize > MaxBatchSize {
+			return c, ErrInvalidBatchSize
+		}
+		c.BatchSize = *update.BatchSize
+	}
+	if update.Concurrency != nil {
+		if *update.Concurrency <= 0 || *update.Concurrency > MaxConcurrency {
+			return c, ErrInvalidConcurrency
+		}
+		c.Concurrency = *update.Concurrency
+	}
+	return c, nil
+}
+
+// leader reports whether this instance may run the scheduler, which is
+// always the case without leader election.
+func (c Config) leader() bool {
+	return c.IsLeader == nil || c.IsLeader()
+}
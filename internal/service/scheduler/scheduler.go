@@ -3,87 +3,307 @@ package scheduler
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/ecoderat/dispatch-go/internal/driver"
 	"github.com/ecoderat/dispatch-go/internal/model"
 	"github.com/ecoderat/dispatch-go/internal/service/message"
 	"github.com/sirupsen/logrus"
 )
 
-const (
-	defaultTickerDuration = 2 * time.Minute
-)
-
 var (
-	ErrSchedulerStop       = errors.New("scheduler: failed to stop previous instance")
+	ErrStopTimeout         = errors.New("scheduler: timed out waiting for the in-flight run to finish")
 	ErrProcessMessages     = errors.New("scheduler: failed to process messages")
 	ErrSendMessage         = errors.New("scheduler: failed to send message")
 	ErrUpdateMessageStatus = errors.New("scheduler: failed to update message status")
+	ErrReleaseClaims       = errors.New("scheduler: failed to release expired claims")
+	ErrRunInProgress       = errors.New("scheduler: a run is already in progress")
+	ErrNotLeader           = errors.New("scheduler: this instance is not the leader")
 )
 
+// Scheduler periodically dispatches due messages. All methods are safe for
+// concurrent use.
 type Scheduler interface {
 	Start(ctx context.Context) error
 	Stop(ctx context.Context) error
+	Config() Config
+	UpdateConfig(update ConfigUpdate) (Config, error)
+	Status() Status
+	RunNow() error
+	Wake()
+}
+
+// Status reports whether the scheduler is running and what its most recent
+// run did. Counters cover the most recent run only, and are updated while it
+// is in progress.
+type Status struct {
+	InstanceID string
+	// Leader is the instance ID of the current leader, empty if leader
+	// election is not used or the leader is unknown.
+	Leader            string
+	Running           bool
+	RunInProgress     bool
+	LastRunStartedAt  *time.Time
+	LastRunFinishedAt *time.Time
+	NextRunAt         *time.Time
+	Attempted         int
+	Sent              int
+	Failed            int
+	// LastError is the error that ended the most recent run early, if any.
+	LastError string
 }
 
 type scheduler struct {
-	ctx     context.Context
-	cancel  context.CancelFunc
-	ticker  *time.Ticker
+	// mu guards every field below it.
+	mu      sync.Mutex
+	config  Config
+	status  Status
 	running bool
+	// ctx is cancelled by Stop. Runs stop claiming new batches once it is
+	// done, but messages already claimed are still sent and recorded.
+	ctx    context.Context
+	cancel context.CancelFunc
+	ticker *time.Ticker
+	// loopDone is closed when the loop goroutine has returned.
+	loopDone chan struct{}
+	// runDone is closed when the run in progress has finished.
+	runDone chan struct{}
+	// runCancel cancels a run started by RunNow while the loop is stopped.
+	runCancel context.CancelFunc
+
+	// wake holds a pending Wake call for the loop.
+	wake chan struct{}
 
 	messageService message.Service
 	logger         *logrus.Logger
 }
 
-func New(messageService message.Service, logger *logrus.Logger) Scheduler {
-	ctx, cancel := context.WithCancel(context.Background())
+func New(messageService message.Service, logger *logrus.Logger, config Config) Scheduler {
 	return &scheduler{
+		config:         config.withDefaults(),
+		wake:           make(chan struct{}, 1),
 		messageService: messageService,
-		ctx:            ctx,
-		cancel:         cancel,
-		ticker:         time.NewTicker(defaultTickerDuration),
-		running:        false,
 		logger:         logger,
 	}
 }
 
+// Start runs the dispatch loop in the background until Stop is called. The
+// first run starts immediately. The loop does not depend on ctx, so it keeps
+// running after the caller, such as an HTTP request, has finished. Starting
+// a running scheduler has no effect. With leader election, Start returns
+// ErrNotLeader unless this instance is the leader.
 func (s *scheduler) Start(ctx context.Context) error {
-	err := s.Stop(ctx) // Stop any previous instance before starting a new one
-	if err != nil {
-		s.logger.WithError(err).Error(ErrSchedulerStop)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return nil
+	}
+	if !s.config.leader() {
+		return ErrNotLeader
 	}
 
 	s.ctx, s.cancel = context.WithCancel(context.Background())
-	s.ticker = time.NewTicker(defaultTickerDuration)
+	s.ticker = time.NewTicker(s.config.Interval)
+	s.loopDone = make(chan struct{})
 	s.running = true
-	errChan := make(chan error)
-	// Immediately process messages on start
-	go func() {
-		if err := s.processMessages(); err != nil {
-			errChan <- err
+	s.scheduleNextRun(time.Now())
+
+	go s.loop(s.ctx, s.ticker, s.loopDone)
+
+	s.logger.WithField("interval", s.config.Interval).Info("Scheduler started")
+	return nil
+}
+
+// Stop ends the dispatch loop and waits for the run in progress, if any, to
+// finish, including a run started by RunNow while the loop was stopped. If
+// ctx is done first, Stop returns ErrStopTimeout and the run keeps going in
+// the background. Stop waits even if another caller has already stopped the
+// scheduler, so every caller can rely on no run being in progress once it
+// returns nil.
+func (s *scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	wasRunning := s.running
+	if s.running {
+		s.cancel()
+		s.ticker.Stop()
+		s.running = false
+	}
+	if s.runCancel != nil {
+		s.runCancel()
+	}
+	loopDone := s.loopDone
+	s.mu.Unlock()
+
+	if loopDone != nil {
+		select {
+		case <-loopDone:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrStopTimeout, ctx.Err())
 		}
-		for {
-			select {
-			case <-s.ticker.C:
-				if err := s.processMessages(); err != nil {
-					errChan <- err
-				}
-			case <-ctx.Done():
-				s.running = false
-				close(errChan)
-				return
-			case <-s.ctx.Done():
-				s.running = false
-				close(errChan)
+	}
+
+	// A run started with RunNow may still be in progress.
+	s.mu.Lock()
+	runDone := s.runDone
+	s.mu.Unlock()
+
+	if runDone != nil {
+		select {
+		case <-runDone:
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrStopTimeout, ctx.Err())
+		}
+	}
+
+	if wasRunning {
+		s.logger.Info("Scheduler stopped")
+	}
+	return nil
+}
+
+// Wake asks the running scheduler to run soon, without waiting for the next
+// tick. Wake-ups within Config.Debounce of each other result in one run.
+func (s *scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// loop performs a run right away, then on every tick and after every
+// debounced wake-up until ctx is done.
+func (s *scheduler) loop(ctx context.Context, ticker *time.Ticker, done chan struct{}) {
+	defer close(done)
+
+	var debounce <-chan time.Time
+
+	s.scheduledRun(ctx)
+	for {
+		select {
+		case <-s.wake:
+			if debounce == nil {
+				debounce = time.After(s.Config().Debounce)
+			}
+		case <-debounce:
+			debounce = nil
+			s.scheduledRun(ctx)
+		case t := <-ticker.C:
+			// A tick may be delivered together with the cancellation.
+			if ctx.Err() != nil {
 				return
 			}
+			s.mu.Lock()
+			s.scheduleNextRun(t)
+			s.mu.Unlock()
+			s.scheduledRun(ctx)
+		case <-ctx.Done():
+			return
 		}
-	}()
+	}
+}
+
+// scheduledRun performs a run from the loop, skipping it if another run is in
+// progress.
+func (s *scheduler) scheduledRun(ctx context.Context) {
+	if !s.beginRun() {
+		s.logger.Info("Skipping scheduled run, a run is already in progress")
+		return
+	}
+
+	if err := s.run(ctx); err != nil {
+		s.logger.WithError(err).Error(ErrProcessMessages)
+	}
+}
+
+// Config returns the current configuration.
+func (s *scheduler) Config() Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.config
+}
+
+// UpdateConfig applies the update and returns the resulting configuration.
+// A new interval takes effect immediately; batch size and concurrency take
+// effect from the next run.
+func (s *scheduler) UpdateConfig(update ConfigUpdate) (Config, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	config, err := s.config.apply(update)
+	if err != nil {
+		return s.config, err
+	}
+
+	intervalChanged := config.Interval != s.config.Interval
+	s.config = config
+	if intervalChanged && s.running {
+		s.ticker.Reset(config.Interval)
+		s.scheduleNextRun(time.Now())
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"interval":    config.Interval,
+		"batch_size":  config.BatchSize,
+		"concurrency": config.Concurrency,
+	}).Info("Scheduler configuration updated")
+
+	return config, nil
+}
+
+// Status returns a snapshot of the scheduler state.
+func (s *scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	status.InstanceID = s.config.InstanceID
+	if s.config.Leader != nil {
+		status.Leader = s.config.Leader()
+	}
+	status.Running = s.running
+	if !s.running {
+		status.NextRunAt = nil
+	}
+	return status
+}
+
+// scheduleNextRun records when the ticker fires next after from. The caller
+// must hold s.mu.
+func (s *scheduler) scheduleNextRun(from time.Time) {
+	next := from.Add(s.config.Interval)
+	s.status.NextRunAt = &next
+}
+
+// RunNow starts a dispatch run in the background without waiting for the
+// next tick. The ticker is left untouched. It returns ErrRunInProgress if a
+// run is already in progress, and ErrNotLeader if leader election is used and
+// this instance is not the leader.
+func (s *scheduler) RunNow() error {
+	if !s.Config().leader() {
+		return ErrNotLeader
+	}
+
+	s.mu.Lock()
+	if !s.beginRunLocked() {
+		s.mu.Unlock()
+		return ErrRunInProgress
+	}
+	// Tie the run to the loop, if any, so that Stop ends it after the
+	// current batch. Without a loop, Stop cancels the run directly. This
+	// happens under the lock that marks the run as in progress, so a
+	// concurrent Stop always finds a run it can cancel.
+	ctx := s.ctx
+	if !s.running {
+		ctx, s.runCancel = context.WithCancel(context.Background())
+	}
+	s.mu.Unlock()
 
-	// Error logging goroutine
 	go func() {
-		for err := range errChan {
+		if err := s.run(ctx); err != nil {
 			s.logger.WithError(err).Error(ErrProcessMessages)
 		}
 	}()
@@ -91,48 +311,177 @@ func (s *scheduler) Start(ctx context.Context) error {
 	return nil
 }
 
-func (s *scheduler) Stop(ctx context.Context) error {
-	if s.running {
-		s.cancel()
-		s.ticker.Stop()
-		s.running = false
+// beginRun marks a run as in progress and resets the last-run status. It
+// reports false if a run is already in progress.
+func (s *scheduler) beginRun() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.beginRunLocked()
+}
+
+// beginRunLocked is beginRun for callers that hold s.mu.
+func (s *scheduler) beginRunLocked() bool {
+	if s.status.RunInProgress {
+		return false
 	}
 
-	return nil
+	started := time.Now()
+	s.status.RunInProgress = true
+	s.runDone = make(chan struct{})
+	s.status.LastRunStartedAt = &started
+	s.status.LastRunFinishedAt = nil
+	s.status.Attempted, s.status.Sent, s.status.Failed = 0, 0, 0
+	s.status.LastError = ""
+	return true
 }
 
-func (s *scheduler) processMessages() error {
-	messages, err := s.messageService.GetUnsentMessages(context.TODO())
+// run performs a run started with beginRun and records its outcome in the
+// status.
+func (s *scheduler) run(ctx context.Context) error {
+	err := s.dispatchDue(ctx)
+
+	finished := time.Now()
+	s.mu.Lock()
+	close(s.runDone)
+	s.runDone = nil
+	if s.runCancel != nil {
+		s.runCancel()
+		s.runCancel = nil
+	}
+	s.status.RunInProgress = false
+	s.status.LastRunFinishedAt = &finished
 	if err != nil {
-		s.logger.WithError(err).Error(ErrProcessMessages)
-		return ErrProcessMessages
+		s.status.LastError = err.Error()
 	}
+	s.mu.Unlock()
 
-	for _, msg := range messages {
-		err = s.messageService.SendMessage(context.TODO(), message.MessageRequest{
-			Recipient: msg.Recipient,
-			Content:   msg.Content,
-		})
+	return err
+}
+
+// recordSend counts a send attempt in the status.
+func (s *scheduler) recordSend(sent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Attempted++
+	if sent {
+		s.status.Sent++
+	} else {
+		s.status.Failed++
+	}
+}
+
+// dispatchDue claims and sends due messages batch by batch, oldest first,
+// until the backlog is drained, the tick budget is used up or ctx is done.
+// Claimed messages are always sent and recorded, even if ctx is done in the
+// meantime.
+func (s *scheduler) dispatchDue(ctx context.Context) error {
+	config := s.Config()
+	deadline := time.Now().Add(config.TickBudget)
+	workCtx := context.WithoutCancel(ctx)
+
+	if _, err := s.messageService.ReleaseExpiredClaims(workCtx); err != nil {
+		s.logger.WithError(err).Error(ErrReleaseClaims)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			s.logger.Info("Scheduler stopping, leaving the remaining messages for the next run")
+			return nil
+		}
+
+		messages, err := s.messageService.ClaimUnsentMessages(workCtx, config.InstanceID, config.LeaseDuration, config.BatchSize)
 		if err != nil {
-			s.logger.WithFields(logrus.Fields{"recipient": msg.Recipient, "id": msg.ID}).WithError(err).Error(ErrSendMessage)
-			err = s.messageService.UpdateMessage(context.TODO(), msg.ID, model.StatusFailed)
-			if err != nil {
-				s.logger.WithFields(logrus.Fields{"id": msg.ID}).WithError(err).Error(ErrUpdateMessageStatus)
-				continue
-			}
-			continue
+			s.logger.WithError(err).Error(ErrProcessMessages)
+			return fmt.Errorf("%w: %v", ErrProcessMessages, err)
 		}
 
-		s.logger.WithFields(logrus.Fields{"recipient": msg.Recipient, "id": msg.ID}).Info("Message sent successfully")
+		s.dispatchAll(workCtx, messages, config.Concurrency, config.LeaseDuration)
+
+		if len(messages) < config.BatchSize {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			s.logger.WithField("budget", config.TickBudget).Info("Tick budget used up, leaving the remaining messages for the next run")
+			return nil
+		}
+	}
+}
+
+// dispatchAll sends the messages on a pool of concurrency workers and returns
+// once every message has been handled. The lease of every message is renewed
+// right before it is sent, since a message may wait in the queue for longer
+// than the lease it was claimed with, and again after every part of a
+// multipart message. Messages whose lease cannot be renewed are skipped, as
+// another instance may already be sending them.
+func (s *scheduler) dispatchAll(ctx context.Context, messages []model.Message, concurrency int, lease time.Duration) {
+	jobs := make(chan model.Message)
+	workers := min(concurrency, len(messages))
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range jobs {
+				if err := s.messageService.RenewLease(ctx, msg, lease); err != nil {
+					s.logger.WithField("id", msg.ID).WithError(err).Warn("Skipping message whose lease could not be renewed")
+					continue
+				}
+				s.recordSend(s.dispatch(ctx, msg, lease))
+			}
+		}()
+	}
+
+	for _, msg := range messages {
+		jobs <- msg
+	}
+	close(jobs)
+	wg.Wait()
+}
 
-		err = s.messageService.UpdateMessage(context.TODO(), msg.ID, model.StatusSent)
+// dispatch sends a single claimed message and records the outcome. The lease
+// is renewed after every part of a multipart message. It reports whether the
+// message was sent.
+func (s *scheduler) dispatch(ctx context.Context, msg model.Message, lease time.Duration) bool {
+	var sentParts []int
+	for _, part := range msg.Parts {
+		sentParts = append(sentParts, part.PartIndex)
+	}
+
+	result, err := s.messageService.SendMessage(ctx, message.MessageRequest{
+		Recipient:  msg.Recipient,
+		Content:    msg.Content,
+		SentParts:  sentParts,
+		MessageID:  msg.ID,
+		LeaseOwner: msg.LeaseOwner,
+		Lease:      lease,
+	})
+	if err != nil {
+		fields := logrus.Fields{"recipient": msg.Recipient, "id": msg.ID, "permanent": result.Permanent}
+		var providerErr *driver.ProviderError
+		if errors.As(err, &providerErr) {
+			fields["http_status"] = providerErr.StatusCode
+			fields["reason"] = providerErr.Reason
+		}
+		s.logger.WithFields(fields).WithError(err).Error(ErrSendMessage)
+		err = s.messageService.MarkFailed(ctx, msg, *result, err)
 		if err != nil {
 			s.logger.WithFields(logrus.Fields{"id": msg.ID}).WithError(err).Error(ErrUpdateMessageStatus)
-			continue
 		}
+		return false
+	}
 
-		s.logger.WithFields(logrus.Fields{"id": msg.ID}).Info("Message status updated to sent")
+	s.logger.WithFields(logrus.Fields{"recipient": msg.Recipient, "id": msg.ID}).Info("Message sent successfully")
+
+	err = s.messageService.MarkSent(ctx, msg, *result)
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{"id": msg.ID}).WithError(err).Error(ErrUpdateMessageStatus)
+		return true
 	}
 
-	return nil
+	s.logger.WithFields(logrus.Fields{"id": msg.ID, "provider_message_id": result.ProviderMessageID}).Info("Message status updated to sent")
+	return true
 }
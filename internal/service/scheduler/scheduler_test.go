@@ -0,0 +1,426 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ecoderat/dispatch-go/internal/driver"
+	"github.com/ecoderat/dispatch-go/internal/model"
+	"github.com/ecoderat/dispatch-go/internal/service/message"
+	mocksvc "github.com/ecoderat/dispatch-go/mock/service/message"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestScheduler(t *testing.T, config Config) (*scheduler, *mocksvc.Service) {
+	svc := mocksvc.NewService(t)
+	logger := &logrus.Logger{}
+	sched := New(svc, logger, config).(*scheduler)
+	return sched, svc
+}
+
+// expectIdle lets any number of runs find nothing to send.
+func expectIdle(svc *mocksvc.Service) {
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Maybe()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil).Maybe()
+}
+
+func waitForRun(t *testing.T, sched *scheduler) Status {
+	t.Helper()
+
+	var status Status
+	assert.Eventually(t, func() bool {
+		status = sched.Status()
+		return status.LastRunFinishedAt != nil
+	}, time.Second, time.Millisecond)
+	return status
+}
+
+func TestScheduler_StartRunsImmediately(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{InstanceID: "node-a", BatchSize: 10})
+
+	msg := model.Message{ID: 1, Recipient: "+123", Content: "hi"}
+	result := &message.SendResult{ProviderMessageID: "p-1"}
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, "node-a", DefaultLeaseDuration, 10).Return([]model.Message{msg}, nil).Once()
+	svc.EXPECT().RenewLease(mock.Anything, msg, DefaultLeaseDuration).Return(nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, message.MessageRequest{Recipient: "+123", Content: "hi", MessageID: 1, Lease: DefaultLeaseDuration}).Return(result, nil).Once()
+	svc.EXPECT().MarkSent(mock.Anything, msg, *result).Return(nil).Once()
+
+	assert.NoError(t, sched.Start(context.Background()))
+	status := waitForRun(t, sched)
+	assert.NoError(t, sched.Stop(context.Background()))
+
+	assert.True(t, status.Running)
+	assert.NotNil(t, status.NextRunAt)
+	assert.Equal(t, 1, status.Attempted)
+	assert.Equal(t, 1, status.Sent)
+	assert.Zero(t, status.Failed)
+	assert.Empty(t, status.LastError)
+	assert.False(t, sched.Status().Running)
+}
+
+func TestScheduler_LoopOutlivesStartContext(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{Interval: 10 * time.Millisecond})
+	expectIdle(svc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, sched.Start(ctx))
+	cancel()
+
+	first := waitForRun(t, sched).LastRunStartedAt
+	assert.Eventually(t, func() bool {
+		started := sched.Status().LastRunStartedAt
+		return started != nil && started.After(*first)
+	}, time.Second, time.Millisecond)
+	assert.True(t, sched.Status().Running)
+
+	assert.NoError(t, sched.Stop(context.Background()))
+}
+
+func TestScheduler_ConcurrentStartStop(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{Interval: time.Millisecond})
+	expectIdle(svc)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, sched.Start(context.Background()))
+		}()
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, sched.Stop(context.Background()))
+		}()
+		go func() {
+			defer wg.Done()
+			_ = sched.Status()
+			_ = sched.RunNow()
+		}()
+	}
+	wg.Wait()
+
+	assert.NoError(t, sched.Stop(context.Background()))
+	assert.False(t, sched.Status().Running)
+}
+
+func TestScheduler_StopWaitsForInFlightRun(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{InstanceID: "node-a", BatchSize: 10})
+
+	msg := model.Message{ID: 1, Recipient: "+123", Content: "hi"}
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, "node-a", DefaultLeaseDuration, 10).Return([]model.Message{msg}, nil).Once()
+	svc.EXPECT().RenewLease(mock.Anything, msg, DefaultLeaseDuration).Return(nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, mock.Anything).
+		RunAndReturn(func(ctx context.Context, _ message.MessageRequest) (*message.SendResult, error) {
+			close(sending)
+			<-release
+			// Stopping must not cancel sends that are already under way.
+			return &message.SendResult{}, ctx.Err()
+		}).Once()
+	svc.EXPECT().MarkSent(mock.Anything, msg, message.SendResult{}).Return(nil).Once()
+
+	assert.NoError(t, sched.Start(context.Background()))
+	<-sending
+
+	stopped := make(chan error)
+	go func() { stopped <- sched.Stop(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the in-flight run finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-stopped)
+	assert.Equal(t, 1, sched.Status().Sent)
+}
+
+func TestScheduler_StopTimesOut(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	release := make(chan struct{})
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).
+		RunAndReturn(func(context.Context) (int, error) {
+			<-release
+			return 0, nil
+		}).Once()
+
+	assert.NoError(t, sched.Start(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := sched.Stop(ctx)
+	assert.ErrorIs(t, err, ErrStopTimeout)
+	assert.False(t, sched.Status().Running)
+
+	// The run notices the stop and ends without claiming more messages.
+	close(release)
+	waitForRun(t, sched)
+}
+
+func TestScheduler_SecondStopWaitsForInFlightRun(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	release := make(chan struct{})
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).
+		RunAndReturn(func(context.Context) (int, error) {
+			<-release
+			return 0, nil
+		}).Once()
+
+	assert.NoError(t, sched.Start(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sched.Stop(ctx), ErrStopTimeout)
+
+	// The scheduler is no longer running, but the run is still in flight.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, sched.Stop(ctx), ErrStopTimeout)
+
+	close(release)
+	assert.NoError(t, sched.Stop(context.Background()))
+	assert.False(t, sched.Status().RunInProgress)
+}
+
+func TestScheduler_StopWaitsForRunNowWhileStopped(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	release := make(chan struct{})
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).
+		RunAndReturn(func(context.Context) (int, error) {
+			<-release
+			return 0, nil
+		}).Once()
+
+	assert.NoError(t, sched.RunNow())
+
+	stopped := make(chan error)
+	go func() { stopped <- sched.Stop(context.Background()) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the run started by RunNow finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Stop cancels the run, so it ends without claiming messages.
+	close(release)
+	assert.NoError(t, <-stopped)
+	assert.False(t, sched.Status().RunInProgress)
+}
+
+func TestScheduler_RunNow(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	release := make(chan struct{})
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).
+		RunAndReturn(func(context.Context) (int, error) {
+			<-release
+			return 0, nil
+		}).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil).Once()
+
+	assert.NoError(t, sched.RunNow())
+	assert.ErrorIs(t, sched.RunNow(), ErrRunInProgress)
+	assert.True(t, sched.Status().RunInProgress)
+
+	close(release)
+	status := waitForRun(t, sched)
+	assert.False(t, status.RunInProgress)
+	assert.False(t, status.Running)
+}
+
+func TestScheduler_RunDrainsBatches(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{InstanceID: "node-a", BatchSize: 2, Concurrency: 2})
+
+	first := []model.Message{{ID: 1, Recipient: "+1"}, {ID: 2, Recipient: "+2"}}
+	second := []model.Message{{ID: 3, Recipient: "+3"}}
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, "node-a", DefaultLeaseDuration, 2).Return(first, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, "node-a", DefaultLeaseDuration, 2).Return(second, nil).Once()
+	svc.EXPECT().RenewLease(mock.Anything, mock.Anything, DefaultLeaseDuration).Return(nil).Times(3)
+	svc.EXPECT().SendMessage(mock.Anything, message.MessageRequest{Recipient: "+1", MessageID: 1, Lease: DefaultLeaseDuration}).Return(&message.SendResult{}, nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, message.MessageRequest{Recipient: "+2", MessageID: 2, Lease: DefaultLeaseDuration}).Return(&message.SendResult{}, nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, message.MessageRequest{Recipient: "+3", MessageID: 3, Lease: DefaultLeaseDuration}).Return(&message.SendResult{}, errors.New("provider down")).Once()
+	svc.EXPECT().MarkSent(mock.Anything, mock.Anything, message.SendResult{}).Return(nil).Twice()
+	svc.EXPECT().MarkFailed(mock.Anything, second[0], message.SendResult{}, mock.Anything).Return(nil).Once()
+
+	assert.NoError(t, sched.RunNow())
+	status := waitForRun(t, sched)
+
+	assert.Equal(t, 3, status.Attempted)
+	assert.Equal(t, 2, status.Sent)
+	assert.Equal(t, 1, status.Failed)
+}
+
+func TestScheduler_RunResumesMultipart(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	msg := model.Message{ID: 1, Recipient: "+123", Content: "long", LeaseOwner: "node-a", Parts: []model.MessagePart{{PartIndex: 1}, {PartIndex: 2}}}
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]model.Message{msg}, nil).Once()
+	svc.EXPECT().RenewLease(mock.Anything, msg, DefaultLeaseDuration).Return(nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, message.MessageRequest{Recipient: "+123", Content: "long", SentParts: []int{1, 2}, MessageID: 1, LeaseOwner: "node-a", Lease: DefaultLeaseDuration}).Return(&message.SendResult{}, nil).Once()
+	svc.EXPECT().MarkSent(mock.Anything, msg, message.SendResult{}).Return(nil).Once()
+
+	assert.NoError(t, sched.RunNow())
+	status := waitForRun(t, sched)
+
+	assert.Equal(t, 1, status.Sent)
+}
+
+func TestScheduler_RunPassesProviderError(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	msg := model.Message{ID: 1, Recipient: "+123", Content: "hi"}
+	result := &message.SendResult{HTTPStatus: 400, Permanent: true}
+	sendErr := fmt.Errorf("%w: %w", message.ErrSendMessage, &driver.ProviderError{StatusCode: 400, Reason: driver.ReasonInvalidRequest})
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]model.Message{msg}, nil).Once()
+	svc.EXPECT().RenewLease(mock.Anything, msg, DefaultLeaseDuration).Return(nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, mock.Anything).Return(result, sendErr).Once()
+	svc.EXPECT().MarkFailed(mock.Anything, msg, *result, mock.MatchedBy(func(err error) bool {
+		var providerErr *driver.ProviderError
+		return errors.As(err, &providerErr) && providerErr.StatusCode == 400
+	})).Return(nil).Once()
+
+	assert.NoError(t, sched.RunNow())
+	status := waitForRun(t, sched)
+
+	assert.Equal(t, 1, status.Failed)
+}
+
+func TestScheduler_RunSkipsMessagesWithLostLease(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	lost := model.Message{ID: 1, Recipient: "+1"}
+	kept := model.Message{ID: 2, Recipient: "+2"}
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return([]model.Message{lost, kept}, nil).Once()
+	svc.EXPECT().RenewLease(mock.Anything, lost, DefaultLeaseDuration).Return(message.ErrLeaseLost).Once()
+	svc.EXPECT().RenewLease(mock.Anything, kept, DefaultLeaseDuration).Return(nil).Once()
+	svc.EXPECT().SendMessage(mock.Anything, message.MessageRequest{Recipient: "+2", MessageID: 2, Lease: DefaultLeaseDuration}).Return(&message.SendResult{}, nil).Once()
+	svc.EXPECT().MarkSent(mock.Anything, kept, message.SendResult{}).Return(nil).Once()
+
+	assert.NoError(t, sched.RunNow())
+	status := waitForRun(t, sched)
+
+	assert.Equal(t, 1, status.Attempted)
+	assert.Equal(t, 1, status.Sent)
+}
+
+func TestScheduler_RunRecordsClaimError(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).Return(0, nil).Once()
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, message.ErrClaimMessages).Once()
+
+	assert.NoError(t, sched.RunNow())
+	status := waitForRun(t, sched)
+
+	assert.Contains(t, status.LastError, message.ErrClaimMessages.Error())
+	assert.Zero(t, status.Attempted)
+}
+
+func TestScheduler_UpdateConfig(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{})
+	expectIdle(svc)
+
+	assert.NoError(t, sched.Start(context.Background()))
+	defer sched.Stop(context.Background())
+
+	interval := 30 * time.Second
+	batchSize := 500
+	config, err := sched.UpdateConfig(ConfigUpdate{Interval: &interval, BatchSize: &batchSize})
+	assert.NoError(t, err)
+	assert.Equal(t, interval, config.Interval)
+	assert.Equal(t, batchSize, config.BatchSize)
+	assert.Equal(t, DefaultConcurrency, config.Concurrency)
+	assert.WithinDuration(t, time.Now().Add(interval), *sched.Status().NextRunAt, time.Second)
+
+	tooMany := MaxConcurrency + 1
+	_, err = sched.UpdateConfig(ConfigUpdate{Concurrency: &tooMany})
+	assert.ErrorIs(t, err, ErrInvalidConcurrency)
+	assert.Equal(t, DefaultConcurrency, sched.Config().Concurrency)
+
+	tooShort := time.Millisecond
+	_, err = sched.UpdateConfig(ConfigUpdate{Interval: &tooShort})
+	assert.ErrorIs(t, err, ErrInvalidInterval)
+}
+
+func TestScheduler_WakeDebouncesRuns(t *testing.T) {
+	sched, svc := newTestScheduler(t, Config{Interval: time.Hour, Debounce: 10 * time.Millisecond})
+
+	var mu sync.Mutex
+	runs := 0
+	svc.EXPECT().ReleaseExpiredClaims(mock.Anything).
+		RunAndReturn(func(context.Context) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			runs++
+			return 0, nil
+		})
+	svc.EXPECT().ClaimUnsentMessages(mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil, nil)
+	countRuns := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs
+	}
+
+	assert.NoError(t, sched.Start(context.Background()))
+	defer sched.Stop(context.Background())
+	waitForRun(t, sched)
+
+	for i := 0; i < 5; i++ {
+		sched.Wake()
+	}
+
+	assert.Eventually(t, func() bool { return countRuns() == 2 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, countRuns())
+}
+
+func TestScheduler_StatusReportsLeader(t *testing.T) {
+	sched, _ := newTestScheduler(t, Config{InstanceID: "node-a", Leader: func() string { return "node-b" }})
+
+	status := sched.Status()
+	assert.Equal(t, "node-a", status.InstanceID)
+	assert.Equal(t, "node-b", status.Leader)
+	assert.False(t, status.Running)
+}
+
+func TestScheduler_OnlyLeaderRuns(t *testing.T) {
+	var mu sync.Mutex
+	isLeader := false
+	sched, svc := newTestScheduler(t, Config{IsLeader: func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return isLeader
+	}})
+	expectIdle(svc)
+
+	assert.ErrorIs(t, sched.Start(context.Background()), ErrNotLeader)
+	assert.ErrorIs(t, sched.RunNow(), ErrNotLeader)
+	assert.False(t, sched.Status().Running)
+	assert.Nil(t, sched.Status().LastRunStartedAt)
+
+	mu.Lock()
+	isLeader = true
+	mu.Unlock()
+
+	assert.NoError(t, sched.Start(context.Background()))
+	waitForRun(t, sched)
+	assert.NoError(t, sched.Stop(context.Background()))
+}
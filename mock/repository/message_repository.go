@@ -7,6 +7,8 @@ import (
 
 	model "github.com/ecoderat/dispatch-go/internal/model"
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // MessageRepository is an autogenerated mock type for the MessageRepository type
@@ -22,8 +24,117 @@ func (_m *MessageRepository) EXPECT() *MessageRepository_Expecter {
 	return &MessageRepository_Expecter{mock: &_m.Mock}
 }
 
+// Cancel provides a mock function with given fields: ctx, id
+func (_m *MessageRepository) Cancel(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Cancel")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MessageRepository_Cancel_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Cancel'
+type MessageRepository_Cancel_Call struct {
+	*mock.Call
+}
+
+// Cancel is a helper method to define mock.On call
+//   - ctx context.Context
+//   - id int
+func (_e *MessageRepository_Expecter) Cancel(ctx interface{}, id interface{}) *MessageRepository_Cancel_Call {
+	return &MessageRepository_Cancel_Call{Call: _e.mock.On("Cancel", ctx, id)}
+}
+
+func (_c *MessageRepository_Cancel_Call) Run(run func(ctx context.Context, id int)) *MessageRepository_Cancel_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_Cancel_Call) Return(_a0 error) *MessageRepository_Cancel_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MessageRepository_Cancel_Call) RunAndReturn(run func(context.Context, int) error) *MessageRepository_Cancel_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// Claim provides a mock function with given fields: ctx, owner, now, lease, limit
+func (_m *MessageRepository) Claim(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int) ([]model.Message, error) {
+	ret := _m.Called(ctx, owner, now, lease, limit)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Claim")
+	}
+
+	var r0 []model.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Duration, int) ([]model.Message, error)); ok {
+		return rf(ctx, owner, now, lease, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Duration, int) []model.Message); ok {
+		r0 = rf(ctx, owner, now, lease, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Duration, int) error); ok {
+		r1 = rf(ctx, owner, now, lease, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MessageRepository_Claim_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Claim'
+type MessageRepository_Claim_Call struct {
+	*mock.Call
+}
+
+// Claim is a helper method to define mock.On call
+//   - ctx context.Context
+//   - owner string
+//   - now time.Time
+//   - lease time.Duration
+//   - limit int
+func (_e *MessageRepository_Expecter) Claim(ctx interface{}, owner interface{}, now interface{}, lease interface{}, limit interface{}) *MessageRepository_Claim_Call {
+	return &MessageRepository_Claim_Call{Call: _e.mock.On("Claim", ctx, owner, now, lease, limit)}
+}
+
+func (_c *MessageRepository_Claim_Call) Run(run func(ctx context.Context, owner string, now time.Time, lease time.Duration, limit int)) *MessageRepository_Claim_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(time.Time), args[3].(time.Duration), args[4].(int))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_Claim_Call) Return(_a0 []model.Message, _a1 error) *MessageRepository_Claim_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MessageRepository_Claim_Call) RunAndReturn(run func(context.Context, string, time.Time, time.Duration, int) ([]model.Message, error)) *MessageRepository_Claim_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
 // Create provides a mock function with given fields: ctx, message
-func (_m *MessageRepository) Create(ctx context.Context, message model.Message) error {
+func (_m *MessageRepository) Create(ctx context.Context, message *model.Message) error {
 	ret := _m.Called(ctx, message)
 
 	if len(ret) == 0 {
@@ -31,7 +142,7 @@ func (_m *MessageRepository) Create(ctx context.Context, message model.Message)
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, model.Message) error); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *model.Message) error); ok {
 		r0 = rf(ctx, message)
 	} else {
 		r0 = ret.Error(0)
@@ -47,14 +158,14 @@ type MessageRepository_Create_Call struct {
 
 // Create is a helper method to define mock.On call
 //   - ctx context.Context
-//   - message model.Message
+//   - message *model.Message
 func (_e *MessageRepository_Expecter) Create(ctx interface{}, message interface{}) *MessageRepository_Create_Call {
 	return &MessageRepository_Create_Call{Call: _e.mock.On("Create", ctx, message)}
 }
 
-func (_c *MessageRepository_Create_Call) Run(run func(ctx context.Context, message model.Message)) *MessageRepository_Create_Call {
+func (_c *MessageRepository_Create_Call) Run(run func(ctx context.Context, message *model.Message)) *MessageRepository_Create_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(model.Message))
+		run(args[0].(context.Context), args[1].(*model.Message))
 	})
 	return _c
 }
@@ -64,7 +175,54 @@ func (_c *MessageRepository_Create_Call) Return(_a0 error) *MessageRepository_Cr
 	return _c
 }
 
-func (_c *MessageRepository_Create_Call) RunAndReturn(run func(context.Context, model.Message) error) *MessageRepository_Create_Call {
+func (_c *MessageRepository_Create_Call) RunAndReturn(run func(context.Context, *model.Message) error) *MessageRepository_Create_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// CreateMany provides a mock function with given fields: ctx, messages
+func (_m *MessageRepository) CreateMany(ctx context.Context, messages []model.Message) error {
+	ret := _m.Called(ctx, messages)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateMany")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, []model.Message) error); ok {
+		r0 = rf(ctx, messages)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MessageRepository_CreateMany_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateMany'
+type MessageRepository_CreateMany_Call struct {
+	*mock.Call
+}
+
+// CreateMany is a helper method to define mock.On call
+//   - ctx context.Context
+//   - messages []model.Message
+func (_e *MessageRepository_Expecter) CreateMany(ctx interface{}, messages interface{}) *MessageRepository_CreateMany_Call {
+	return &MessageRepository_CreateMany_Call{Call: _e.mock.On("CreateMany", ctx, messages)}
+}
+
+func (_c *MessageRepository_CreateMany_Call) Run(run func(ctx context.Context, messages []model.Message)) *MessageRepository_CreateMany_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].([]model.Message))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_CreateMany_Call) Return(_a0 error) *MessageRepository_CreateMany_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MessageRepository_CreateMany_Call) RunAndReturn(run func(context.Context, []model.Message) error) *MessageRepository_CreateMany_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -116,36 +274,88 @@ func (_c *MessageRepository_Delete_Call) RunAndReturn(run func(context.Context,
 	return _c
 }
 
-// GetAll provides a mock function with given fields: ctx, status
-func (_m *MessageRepository) GetAll(ctx context.Context, status ...model.MessageStatus) ([]model.Message, error) {
-	_va := make([]interface{}, len(status))
-	for _i := range status {
-		_va[_i] = status[_i]
+// GetByID provides a mock function with given fields: ctx, id
+func (_m *MessageRepository) GetByID(ctx context.Context, id int) (*model.Message, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetByID")
 	}
-	var _ca []interface{}
-	_ca = append(_ca, ctx)
-	_ca = append(_ca, _va...)
-	ret := _m.Called(_ca...)
+
+	var r0 *model.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (*model.Message, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) *model.Message); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MessageRepository_GetByID_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetByID'
+type MessageRepository_GetByID_Call struct {
+	*mock.Call
+}
+
+// GetByID is a helper method to define mock.On call
+//   - ctx context.Context
+//   - id int
+func (_e *MessageRepository_Expecter) GetByID(ctx interface{}, id interface{}) *MessageRepository_GetByID_Call {
+	return &MessageRepository_GetByID_Call{Call: _e.mock.On("GetByID", ctx, id)}
+}
+
+func (_c *MessageRepository_GetByID_Call) Run(run func(ctx context.Context, id int)) *MessageRepository_GetByID_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_GetByID_Call) Return(_a0 *model.Message, _a1 error) *MessageRepository_GetByID_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MessageRepository_GetByID_Call) RunAndReturn(run func(context.Context, int) (*model.Message, error)) *MessageRepository_GetByID_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// List provides a mock function with given fields: ctx, filter
+func (_m *MessageRepository) List(ctx context.Context, filter model.MessageFilter) ([]model.Message, error) {
+	ret := _m.Called(ctx, filter)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetAll")
+		panic("no return value specified for List")
 	}
 
 	var r0 []model.Message
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, ...model.MessageStatus) ([]model.Message, error)); ok {
-		return rf(ctx, status...)
+	if rf, ok := ret.Get(0).(func(context.Context, model.MessageFilter) ([]model.Message, error)); ok {
+		return rf(ctx, filter)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, ...model.MessageStatus) []model.Message); ok {
-		r0 = rf(ctx, status...)
+	if rf, ok := ret.Get(0).(func(context.Context, model.MessageFilter) []model.Message); ok {
+		r0 = rf(ctx, filter)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]model.Message)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, ...model.MessageStatus) error); ok {
-		r1 = rf(ctx, status...)
+	if rf, ok := ret.Get(1).(func(context.Context, model.MessageFilter) error); ok {
+		r1 = rf(ctx, filter)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -153,53 +363,202 @@ func (_m *MessageRepository) GetAll(ctx context.Context, status ...model.Message
 	return r0, r1
 }
 
-// MessageRepository_GetAll_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetAll'
-type MessageRepository_GetAll_Call struct {
+// MessageRepository_List_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'List'
+type MessageRepository_List_Call struct {
 	*mock.Call
 }
 
-// GetAll is a helper method to define mock.On call
+// List is a helper method to define mock.On call
 //   - ctx context.Context
-//   - status ...model.MessageStatus
-func (_e *MessageRepository_Expecter) GetAll(ctx interface{}, status ...interface{}) *MessageRepository_GetAll_Call {
-	return &MessageRepository_GetAll_Call{Call: _e.mock.On("GetAll",
-		append([]interface{}{ctx}, status...)...)}
+//   - filter model.MessageFilter
+func (_e *MessageRepository_Expecter) List(ctx interface{}, filter interface{}) *MessageRepository_List_Call {
+	return &MessageRepository_List_Call{Call: _e.mock.On("List", ctx, filter)}
 }
 
-func (_c *MessageRepository_GetAll_Call) Run(run func(ctx context.Context, status ...model.MessageStatus)) *MessageRepository_GetAll_Call {
+func (_c *MessageRepository_List_Call) Run(run func(ctx context.Context, filter model.MessageFilter)) *MessageRepository_List_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		variadicArgs := make([]model.MessageStatus, len(args)-1)
-		for i, a := range args[1:] {
-			if a != nil {
-				variadicArgs[i] = a.(model.MessageStatus)
-			}
-		}
-		run(args[0].(context.Context), variadicArgs...)
+		run(args[0].(context.Context), args[1].(model.MessageFilter))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_List_Call) Return(_a0 []model.Message, _a1 error) *MessageRepository_List_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *MessageRepository_List_Call) RunAndReturn(run func(context.Context, model.MessageFilter) ([]model.Message, error)) *MessageRepository_List_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RecordDelivery provides a mock function with given fields: ctx, id, delivery
+func (_m *MessageRepository) RecordDelivery(ctx context.Context, id int, delivery model.Delivery) error {
+	ret := _m.Called(ctx, id, delivery)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RecordDelivery")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, model.Delivery) error); ok {
+		r0 = rf(ctx, id, delivery)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MessageRepository_RecordDelivery_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordDelivery'
+type MessageRepository_RecordDelivery_Call struct {
+	*mock.Call
+}
+
+// RecordDelivery is a helper method to define mock.On call
+//   - ctx context.Context
+//   - id int
+//   - delivery model.Delivery
+func (_e *MessageRepository_Expecter) RecordDelivery(ctx interface{}, id interface{}, delivery interface{}) *MessageRepository_RecordDelivery_Call {
+	return &MessageRepository_RecordDelivery_Call{Call: _e.mock.On("RecordDelivery", ctx, id, delivery)}
+}
+
+func (_c *MessageRepository_RecordDelivery_Call) Run(run func(ctx context.Context, id int, delivery model.Delivery)) *MessageRepository_RecordDelivery_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(model.Delivery))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_RecordDelivery_Call) Return(_a0 error) *MessageRepository_RecordDelivery_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MessageRepository_RecordDelivery_Call) RunAndReturn(run func(context.Context, int, model.Delivery) error) *MessageRepository_RecordDelivery_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RecordPart provides a mock function with given fields: ctx, id, owner, part, expiresAt
+func (_m *MessageRepository) RecordPart(ctx context.Context, id int, owner string, part model.MessagePart, expiresAt time.Time) error {
+	ret := _m.Called(ctx, id, owner, part, expiresAt)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RecordPart")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, model.MessagePart, time.Time) error); ok {
+		r0 = rf(ctx, id, owner, part, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// MessageRepository_RecordPart_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RecordPart'
+type MessageRepository_RecordPart_Call struct {
+	*mock.Call
+}
+
+// RecordPart is a helper method to define mock.On call
+//   - ctx context.Context
+//   - id int
+//   - owner string
+//   - part model.MessagePart
+//   - expiresAt time.Time
+func (_e *MessageRepository_Expecter) RecordPart(ctx interface{}, id interface{}, owner interface{}, part interface{}, expiresAt interface{}) *MessageRepository_RecordPart_Call {
+	return &MessageRepository_RecordPart_Call{Call: _e.mock.On("RecordPart", ctx, id, owner, part, expiresAt)}
+}
+
+func (_c *MessageRepository_RecordPart_Call) Run(run func(ctx context.Context, id int, owner string, part model.MessagePart, expiresAt time.Time)) *MessageRepository_RecordPart_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int), args[2].(string), args[3].(model.MessagePart), args[4].(time.Time))
+	})
+	return _c
+}
+
+func (_c *MessageRepository_RecordPart_Call) Return(_a0 error) *MessageRepository_RecordPart_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *MessageRepository_RecordPart_Call) RunAndReturn(run func(context.Context, int, string, model.MessagePart, time.Time) error) *MessageRepository_RecordPart_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReleaseExpired provides a mock function with given fields: ctx, now, maxAttempts
+func (_m *MessageRepository) ReleaseExpired(ctx context.Context, now time.Time, maxAttempts int) (int, error) {
+	ret := _m.Called(ctx, now, maxAttempts)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseExpired")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) (int, error)); ok {
+		return rf(ctx, now, maxAttempts)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, int) int); ok {
+		r0 = rf(ctx, now, maxAttempts)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, int) error); ok {
+		r1 = rf(ctx, now, maxAttempts)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// MessageRepository_ReleaseExpired_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReleaseExpired'
+type MessageRepository_ReleaseExpired_Call struct {
+	*mock.Call
+}
+
+// ReleaseExpired is a helper method to define mock.On call
+//   - ctx context.Context
+//   - now time.Time
+//   - maxAttempts int
+func (_e *MessageRepository_Expecter) ReleaseExpired(ctx interface{}, now interface{}, maxAttempts interface{}) *MessageRepository_ReleaseExpired_Call {
+	return &MessageRepository_ReleaseExpired_Call{Call: _e.mock.On("ReleaseExpired", ctx, now, maxAttempts)}
+}
+
+func (_c *MessageRepository_ReleaseExpired_Call) Run(run func(ctx context.Context, now time.Time, maxAttempts int)) *MessageRepository_ReleaseExpired_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(time.Time), args[2].(int))
 	})
 	return _c
 }
 
-func (_c *MessageRepository_GetAll_Call) Return(_a0 []model.Message, _a1 error) *MessageRepository_GetAll_Call {
+func (_c *MessageRepository_ReleaseExpired_Call) Return(_a0 int, _a1 error) *MessageRepository_ReleaseExpired_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *MessageRepository_GetAll_Call) RunAndReturn(run func(context.Context, ...model.MessageStatus) ([]model.Message, error)) *MessageRepository_GetAll_Call {
+func (_c *MessageRepository_ReleaseExpired_Call) RunAndReturn(run func(context.Context, time.Time, int) (int, error)) *MessageRepository_ReleaseExpired_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// Update provides a mock function with given fields: ctx, id, status
-func (_m *MessageRepository) Update(ctx context.Context, id int, status model.MessageStatus) error {
-	ret := _m.Called(ctx, id, status)
+// RenewLease provides a mock function with given fields: ctx, id, owner, expiresAt
+func (_m *MessageRepository) RenewLease(ctx context.Context, id int, owner string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, id, owner, expiresAt)
 
 	if len(ret) == 0 {
-		panic("no return value specified for Update")
+		panic("no return value specified for RenewLease")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int, model.MessageStatus) error); ok {
-		r0 = rf(ctx, id, status)
+	if rf, ok := ret.Get(0).(func(context.Context, int, string, time.Time) error); ok {
+		r0 = rf(ctx, id, owner, expiresAt)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -207,32 +566,33 @@ func (_m *MessageRepository) Update(ctx context.Context, id int, status model.Me
 	return r0
 }
 
-// MessageRepository_Update_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'Update'
-type MessageRepository_Update_Call struct {
+// MessageRepository_RenewLease_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RenewLease'
+type MessageRepository_RenewLease_Call struct {
 	*mock.Call
 }
 
-// Update is a helper method to define mock.On call
+// RenewLease is a helper method to define mock.On call
 //   - ctx context.Context
 //   - id int
-//   - status model.MessageStatus
-func (_e *MessageRepository_Expecter) Update(ctx interface{}, id interface{}, status interface{}) *MessageRepository_Update_Call {
-	return &MessageRepository_Update_Call{Call: _e.mock.On("Update", ctx, id, status)}
+//   - owner string
+//   - expiresAt time.Time
+func (_e *MessageRepository_Expecter) RenewLease(ctx interface{}, id interface{}, owner interface{}, expiresAt interface{}) *MessageRepository_RenewLease_Call {
+	return &MessageRepository_RenewLease_Call{Call: _e.mock.On("RenewLease", ctx, id, owner, expiresAt)}
 }
 
-func (_c *MessageRepository_Update_Call) Run(run func(ctx context.Context, id int, status model.MessageStatus)) *MessageRepository_Update_Call {
+func (_c *MessageRepository_RenewLease_Call) Run(run func(ctx context.Context, id int, owner string, expiresAt time.Time)) *MessageRepository_RenewLease_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(int), args[2].(model.MessageStatus))
+		run(args[0].(context.Context), args[1].(int), args[2].(string), args[3].(time.Time))
 	})
 	return _c
 }
 
-func (_c *MessageRepository_Update_Call) Return(_a0 error) *MessageRepository_Update_Call {
+func (_c *MessageRepository_RenewLease_Call) Return(_a0 error) *MessageRepository_RenewLease_Call {
 	_c.Call.Return(_a0)
 	return _c
 }
 
-func (_c *MessageRepository_Update_Call) RunAndReturn(run func(context.Context, int, model.MessageStatus) error) *MessageRepository_Update_Call {
+func (_c *MessageRepository_RenewLease_Call) RunAndReturn(run func(context.Context, int, string, time.Time) error) *MessageRepository_RenewLease_Call {
 	_c.Call.Return(run)
 	return _c
 }
@@ -9,6 +9,8 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	model "github.com/ecoderat/dispatch-go/internal/model"
+
+	time "time"
 )
 
 // Service is an autogenerated mock type for the Service type
@@ -24,29 +26,76 @@ func (_m *Service) EXPECT() *Service_Expecter {
 	return &Service_Expecter{mock: &_m.Mock}
 }
 
-// GetSentMessages provides a mock function with given fields: ctx
-func (_m *Service) GetSentMessages(ctx context.Context) ([]model.Message, error) {
-	ret := _m.Called(ctx)
+// CancelMessage provides a mock function with given fields: ctx, id
+func (_m *Service) CancelMessage(ctx context.Context, id int) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CancelMessage")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Service_CancelMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CancelMessage'
+type Service_CancelMessage_Call struct {
+	*mock.Call
+}
+
+// CancelMessage is a helper method to define mock.On call
+//   - ctx context.Context
+//   - id int
+func (_e *Service_Expecter) CancelMessage(ctx interface{}, id interface{}) *Service_CancelMessage_Call {
+	return &Service_CancelMessage_Call{Call: _e.mock.On("CancelMessage", ctx, id)}
+}
+
+func (_c *Service_CancelMessage_Call) Run(run func(ctx context.Context, id int)) *Service_CancelMessage_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *Service_CancelMessage_Call) Return(_a0 error) *Service_CancelMessage_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Service_CancelMessage_Call) RunAndReturn(run func(context.Context, int) error) *Service_CancelMessage_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ClaimUnsentMessages provides a mock function with given fields: ctx, owner, lease, limit
+func (_m *Service) ClaimUnsentMessages(ctx context.Context, owner string, lease time.Duration, limit int) ([]model.Message, error) {
+	ret := _m.Called(ctx, owner, lease, limit)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetSentMessages")
+		panic("no return value specified for ClaimUnsentMessages")
 	}
 
 	var r0 []model.Message
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context) ([]model.Message, error)); ok {
-		return rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, int) ([]model.Message, error)); ok {
+		return rf(ctx, owner, lease, limit)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context) []model.Message); ok {
-		r0 = rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, int) []model.Message); ok {
+		r0 = rf(ctx, owner, lease, limit)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]model.Message)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
-		r1 = rf(ctx)
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration, int) error); ok {
+		r1 = rf(ctx, owner, lease, limit)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -54,57 +103,60 @@ func (_m *Service) GetSentMessages(ctx context.Context) ([]model.Message, error)
 	return r0, r1
 }
 
-// Service_GetSentMessages_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetSentMessages'
-type Service_GetSentMessages_Call struct {
+// Service_ClaimUnsentMessages_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ClaimUnsentMessages'
+type Service_ClaimUnsentMessages_Call struct {
 	*mock.Call
 }
 
-// GetSentMessages is a helper method to define mock.On call
+// ClaimUnsentMessages is a helper method to define mock.On call
 //   - ctx context.Context
-func (_e *Service_Expecter) GetSentMessages(ctx interface{}) *Service_GetSentMessages_Call {
-	return &Service_GetSentMessages_Call{Call: _e.mock.On("GetSentMessages", ctx)}
+//   - owner string
+//   - lease time.Duration
+//   - limit int
+func (_e *Service_Expecter) ClaimUnsentMessages(ctx interface{}, owner interface{}, lease interface{}, limit interface{}) *Service_ClaimUnsentMessages_Call {
+	return &Service_ClaimUnsentMessages_Call{Call: _e.mock.On("ClaimUnsentMessages", ctx, owner, lease, limit)}
 }
 
-func (_c *Service_GetSentMessages_Call) Run(run func(ctx context.Context)) *Service_GetSentMessages_Call {
+func (_c *Service_ClaimUnsentMessages_Call) Run(run func(ctx context.Context, owner string, lease time.Duration, limit int)) *Service_ClaimUnsentMessages_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context))
+		run(args[0].(context.Context), args[1].(string), args[2].(time.Duration), args[3].(int))
 	})
 	return _c
 }
 
-func (_c *Service_GetSentMessages_Call) Return(_a0 []model.Message, _a1 error) *Service_GetSentMessages_Call {
+func (_c *Service_ClaimUnsentMessages_Call) Return(_a0 []model.Message, _a1 error) *Service_ClaimUnsentMessages_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *Service_GetSentMessages_Call) RunAndReturn(run func(context.Context) ([]model.Message, error)) *Service_GetSentMessages_Call {
+func (_c *Service_ClaimUnsentMessages_Call) RunAndReturn(run func(context.Context, string, time.Duration, int) ([]model.Message, error)) *Service_ClaimUnsentMessages_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// GetUnsentMessages provides a mock function with given fields: ctx
-func (_m *Service) GetUnsentMessages(ctx context.Context) ([]model.Message, error) {
-	ret := _m.Called(ctx)
+// CreateMessage provides a mock function with given fields: ctx, _a1
+func (_m *Service) CreateMessage(ctx context.Context, _a1 message.MessageRequest) (*model.Message, error) {
+	ret := _m.Called(ctx, _a1)
 
 	if len(ret) == 0 {
-		panic("no return value specified for GetUnsentMessages")
+		panic("no return value specified for CreateMessage")
 	}
 
-	var r0 []model.Message
+	var r0 *model.Message
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context) ([]model.Message, error)); ok {
-		return rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, message.MessageRequest) (*model.Message, error)); ok {
+		return rf(ctx, _a1)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context) []model.Message); ok {
-		r0 = rf(ctx)
+	if rf, ok := ret.Get(0).(func(context.Context, message.MessageRequest) *model.Message); ok {
+		r0 = rf(ctx, _a1)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]model.Message)
+			r0 = ret.Get(0).(*model.Message)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
-		r1 = rf(ctx)
+	if rf, ok := ret.Get(1).(func(context.Context, message.MessageRequest) error); ok {
+		r1 = rf(ctx, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -112,45 +164,223 @@ func (_m *Service) GetUnsentMessages(ctx context.Context) ([]model.Message, erro
 	return r0, r1
 }
 
-// Service_GetUnsentMessages_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetUnsentMessages'
-type Service_GetUnsentMessages_Call struct {
+// Service_CreateMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateMessage'
+type Service_CreateMessage_Call struct {
 	*mock.Call
 }
 
-// GetUnsentMessages is a helper method to define mock.On call
+// CreateMessage is a helper method to define mock.On call
 //   - ctx context.Context
-func (_e *Service_Expecter) GetUnsentMessages(ctx interface{}) *Service_GetUnsentMessages_Call {
-	return &Service_GetUnsentMessages_Call{Call: _e.mock.On("GetUnsentMessages", ctx)}
+//   - _a1 message.MessageRequest
+func (_e *Service_Expecter) CreateMessage(ctx interface{}, _a1 interface{}) *Service_CreateMessage_Call {
+	return &Service_CreateMessage_Call{Call: _e.mock.On("CreateMessage", ctx, _a1)}
 }
 
-func (_c *Service_GetUnsentMessages_Call) Run(run func(ctx context.Context)) *Service_GetUnsentMessages_Call {
+func (_c *Service_CreateMessage_Call) Run(run func(ctx context.Context, _a1 message.MessageRequest)) *Service_CreateMessage_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context))
+		run(args[0].(context.Context), args[1].(message.MessageRequest))
 	})
 	return _c
 }
 
-func (_c *Service_GetUnsentMessages_Call) Return(_a0 []model.Message, _a1 error) *Service_GetUnsentMessages_Call {
+func (_c *Service_CreateMessage_Call) Return(_a0 *model.Message, _a1 error) *Service_CreateMessage_Call {
 	_c.Call.Return(_a0, _a1)
 	return _c
 }
 
-func (_c *Service_GetUnsentMessages_Call) RunAndReturn(run func(context.Context) ([]model.Message, error)) *Service_GetUnsentMessages_Call {
+func (_c *Service_CreateMessage_Call) RunAndReturn(run func(context.Context, message.MessageRequest) (*model.Message, error)) *Service_CreateMessage_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// SendMessage provides a mock function with given fields: ctx, _a1
-func (_m *Service) SendMessage(ctx context.Context, _a1 message.MessageRequest) error {
-	ret := _m.Called(ctx, _a1)
+// CreateMessages provides a mock function with given fields: ctx, messages
+func (_m *Service) CreateMessages(ctx context.Context, messages []message.MessageRequest) ([]message.BatchResult, error) {
+	ret := _m.Called(ctx, messages)
 
 	if len(ret) == 0 {
-		panic("no return value specified for SendMessage")
+		panic("no return value specified for CreateMessages")
+	}
+
+	var r0 []message.BatchResult
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []message.MessageRequest) ([]message.BatchResult, error)); ok {
+		return rf(ctx, messages)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []message.MessageRequest) []message.BatchResult); ok {
+		r0 = rf(ctx, messages)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]message.BatchResult)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []message.MessageRequest) error); ok {
+		r1 = rf(ctx, messages)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_CreateMessages_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'CreateMessages'
+type Service_CreateMessages_Call struct {
+	*mock.Call
+}
+
+// CreateMessages is a helper method to define mock.On call
+//   - ctx context.Context
+//   - messages []message.MessageRequest
+func (_e *Service_Expecter) CreateMessages(ctx interface{}, messages interface{}) *Service_CreateMessages_Call {
+	return &Service_CreateMessages_Call{Call: _e.mock.On("CreateMessages", ctx, messages)}
+}
+
+func (_c *Service_CreateMessages_Call) Run(run func(ctx context.Context, messages []message.MessageRequest)) *Service_CreateMessages_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].([]message.MessageRequest))
+	})
+	return _c
+}
+
+func (_c *Service_CreateMessages_Call) Return(_a0 []message.BatchResult, _a1 error) *Service_CreateMessages_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_CreateMessages_Call) RunAndReturn(run func(context.Context, []message.MessageRequest) ([]message.BatchResult, error)) *Service_CreateMessages_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// GetMessage provides a mock function with given fields: ctx, id
+func (_m *Service) GetMessage(ctx context.Context, id int) (*model.Message, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetMessage")
+	}
+
+	var r0 *model.Message
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (*model.Message, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) *model.Message); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*model.Message)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_GetMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'GetMessage'
+type Service_GetMessage_Call struct {
+	*mock.Call
+}
+
+// GetMessage is a helper method to define mock.On call
+//   - ctx context.Context
+//   - id int
+func (_e *Service_Expecter) GetMessage(ctx interface{}, id interface{}) *Service_GetMessage_Call {
+	return &Service_GetMessage_Call{Call: _e.mock.On("GetMessage", ctx, id)}
+}
+
+func (_c *Service_GetMessage_Call) Run(run func(ctx context.Context, id int)) *Service_GetMessage_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(int))
+	})
+	return _c
+}
+
+func (_c *Service_GetMessage_Call) Return(_a0 *model.Message, _a1 error) *Service_GetMessage_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_GetMessage_Call) RunAndReturn(run func(context.Context, int) (*model.Message, error)) *Service_GetMessage_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ListMessages provides a mock function with given fields: ctx, filter
+func (_m *Service) ListMessages(ctx context.Context, filter model.MessageFilter) (*message.MessagePage, error) {
+	ret := _m.Called(ctx, filter)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListMessages")
+	}
+
+	var r0 *message.MessagePage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.MessageFilter) (*message.MessagePage, error)); ok {
+		return rf(ctx, filter)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.MessageFilter) *message.MessagePage); ok {
+		r0 = rf(ctx, filter)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*message.MessagePage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.MessageFilter) error); ok {
+		r1 = rf(ctx, filter)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_ListMessages_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ListMessages'
+type Service_ListMessages_Call struct {
+	*mock.Call
+}
+
+// ListMessages is a helper method to define mock.On call
+//   - ctx context.Context
+//   - filter model.MessageFilter
+func (_e *Service_Expecter) ListMessages(ctx interface{}, filter interface{}) *Service_ListMessages_Call {
+	return &Service_ListMessages_Call{Call: _e.mock.On("ListMessages", ctx, filter)}
+}
+
+func (_c *Service_ListMessages_Call) Run(run func(ctx context.Context, filter model.MessageFilter)) *Service_ListMessages_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.MessageFilter))
+	})
+	return _c
+}
+
+func (_c *Service_ListMessages_Call) Return(_a0 *message.MessagePage, _a1 error) *Service_ListMessages_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_ListMessages_Call) RunAndReturn(run func(context.Context, model.MessageFilter) (*message.MessagePage, error)) *Service_ListMessages_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// MarkFailed provides a mock function with given fields: ctx, msg, result, sendErr
+func (_m *Service) MarkFailed(ctx context.Context, msg model.Message, result message.SendResult, sendErr error) error {
+	ret := _m.Called(ctx, msg, result, sendErr)
+
+	if len(ret) == 0 {
+		panic("no return value specified for MarkFailed")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, message.MessageRequest) error); ok {
-		r0 = rf(ctx, _a1)
+	if rf, ok := ret.Get(0).(func(context.Context, model.Message, message.SendResult, error) error); ok {
+		r0 = rf(ctx, msg, result, sendErr)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -158,46 +388,48 @@ func (_m *Service) SendMessage(ctx context.Context, _a1 message.MessageRequest)
 	return r0
 }
 
-// Service_SendMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SendMessage'
-type Service_SendMessage_Call struct {
+// Service_MarkFailed_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MarkFailed'
+type Service_MarkFailed_Call struct {
 	*mock.Call
 }
 
-// SendMessage is a helper method to define mock.On call
+// MarkFailed is a helper method to define mock.On call
 //   - ctx context.Context
-//   - _a1 message.MessageRequest
-func (_e *Service_Expecter) SendMessage(ctx interface{}, _a1 interface{}) *Service_SendMessage_Call {
-	return &Service_SendMessage_Call{Call: _e.mock.On("SendMessage", ctx, _a1)}
+//   - msg model.Message
+//   - result message.SendResult
+//   - sendErr error
+func (_e *Service_Expecter) MarkFailed(ctx interface{}, msg interface{}, result interface{}, sendErr interface{}) *Service_MarkFailed_Call {
+	return &Service_MarkFailed_Call{Call: _e.mock.On("MarkFailed", ctx, msg, result, sendErr)}
 }
 
-func (_c *Service_SendMessage_Call) Run(run func(ctx context.Context, _a1 message.MessageRequest)) *Service_SendMessage_Call {
+func (_c *Service_MarkFailed_Call) Run(run func(ctx context.Context, msg model.Message, result message.SendResult, sendErr error)) *Service_MarkFailed_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(message.MessageRequest))
+		run(args[0].(context.Context), args[1].(model.Message), args[2].(message.SendResult), args[3].(error))
 	})
 	return _c
 }
 
-func (_c *Service_SendMessage_Call) Return(_a0 error) *Service_SendMessage_Call {
+func (_c *Service_MarkFailed_Call) Return(_a0 error) *Service_MarkFailed_Call {
 	_c.Call.Return(_a0)
 	return _c
 }
 
-func (_c *Service_SendMessage_Call) RunAndReturn(run func(context.Context, message.MessageRequest) error) *Service_SendMessage_Call {
+func (_c *Service_MarkFailed_Call) RunAndReturn(run func(context.Context, model.Message, message.SendResult, error) error) *Service_MarkFailed_Call {
 	_c.Call.Return(run)
 	return _c
 }
 
-// UpdateMessage provides a mock function with given fields: ctx, id, status
-func (_m *Service) UpdateMessage(ctx context.Context, id int, status model.MessageStatus) error {
-	ret := _m.Called(ctx, id, status)
+// MarkSent provides a mock function with given fields: ctx, msg, result
+func (_m *Service) MarkSent(ctx context.Context, msg model.Message, result message.SendResult) error {
+	ret := _m.Called(ctx, msg, result)
 
 	if len(ret) == 0 {
-		panic("no return value specified for UpdateMessage")
+		panic("no return value specified for MarkSent")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int, model.MessageStatus) error); ok {
-		r0 = rf(ctx, id, status)
+	if rf, ok := ret.Get(0).(func(context.Context, model.Message, message.SendResult) error); ok {
+		r0 = rf(ctx, msg, result)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -205,32 +437,195 @@ func (_m *Service) UpdateMessage(ctx context.Context, id int, status model.Messa
 	return r0
 }
 
-// Service_UpdateMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'UpdateMessage'
-type Service_UpdateMessage_Call struct {
+// Service_MarkSent_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'MarkSent'
+type Service_MarkSent_Call struct {
 	*mock.Call
 }
 
-// UpdateMessage is a helper method to define mock.On call
+// MarkSent is a helper method to define mock.On call
 //   - ctx context.Context
-//   - id int
-//   - status model.MessageStatus
-func (_e *Service_Expecter) UpdateMessage(ctx interface{}, id interface{}, status interface{}) *Service_UpdateMessage_Call {
-	return &Service_UpdateMessage_Call{Call: _e.mock.On("UpdateMessage", ctx, id, status)}
+//   - msg model.Message
+//   - result message.SendResult
+func (_e *Service_Expecter) MarkSent(ctx interface{}, msg interface{}, result interface{}) *Service_MarkSent_Call {
+	return &Service_MarkSent_Call{Call: _e.mock.On("MarkSent", ctx, msg, result)}
+}
+
+func (_c *Service_MarkSent_Call) Run(run func(ctx context.Context, msg model.Message, result message.SendResult)) *Service_MarkSent_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(model.Message), args[2].(message.SendResult))
+	})
+	return _c
+}
+
+func (_c *Service_MarkSent_Call) Return(_a0 error) *Service_MarkSent_Call {
+	_c.Call.Return(_a0)
+	return _c
+}
+
+func (_c *Service_MarkSent_Call) RunAndReturn(run func(context.Context, model.Message, message.SendResult) error) *Service_MarkSent_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// ReleaseExpiredClaims provides a mock function with given fields: ctx
+func (_m *Service) ReleaseExpiredClaims(ctx context.Context) (int, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseExpiredClaims")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_ReleaseExpiredClaims_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'ReleaseExpiredClaims'
+type Service_ReleaseExpiredClaims_Call struct {
+	*mock.Call
+}
+
+// ReleaseExpiredClaims is a helper method to define mock.On call
+//   - ctx context.Context
+func (_e *Service_Expecter) ReleaseExpiredClaims(ctx interface{}) *Service_ReleaseExpiredClaims_Call {
+	return &Service_ReleaseExpiredClaims_Call{Call: _e.mock.On("ReleaseExpiredClaims", ctx)}
+}
+
+func (_c *Service_ReleaseExpiredClaims_Call) Run(run func(ctx context.Context)) *Service_ReleaseExpiredClaims_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context))
+	})
+	return _c
+}
+
+func (_c *Service_ReleaseExpiredClaims_Call) Return(_a0 int, _a1 error) *Service_ReleaseExpiredClaims_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_ReleaseExpiredClaims_Call) RunAndReturn(run func(context.Context) (int, error)) *Service_ReleaseExpiredClaims_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// RenewLease provides a mock function with given fields: ctx, msg, lease
+func (_m *Service) RenewLease(ctx context.Context, msg model.Message, lease time.Duration) error {
+	ret := _m.Called(ctx, msg, lease)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RenewLease")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.Message, time.Duration) error); ok {
+		r0 = rf(ctx, msg, lease)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
 }
 
-func (_c *Service_UpdateMessage_Call) Run(run func(ctx context.Context, id int, status model.MessageStatus)) *Service_UpdateMessage_Call {
+// Service_RenewLease_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'RenewLease'
+type Service_RenewLease_Call struct {
+	*mock.Call
+}
+
+// RenewLease is a helper method to define mock.On call
+//   - ctx context.Context
+//   - msg model.Message
+//   - lease time.Duration
+func (_e *Service_Expecter) RenewLease(ctx interface{}, msg interface{}, lease interface{}) *Service_RenewLease_Call {
+	return &Service_RenewLease_Call{Call: _e.mock.On("RenewLease", ctx, msg, lease)}
+}
+
+func (_c *Service_RenewLease_Call) Run(run func(ctx context.Context, msg model.Message, lease time.Duration)) *Service_RenewLease_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(context.Context), args[1].(int), args[2].(model.MessageStatus))
+		run(args[0].(context.Context), args[1].(model.Message), args[2].(time.Duration))
 	})
 	return _c
 }
 
-func (_c *Service_UpdateMessage_Call) Return(_a0 error) *Service_UpdateMessage_Call {
+func (_c *Service_RenewLease_Call) Return(_a0 error) *Service_RenewLease_Call {
 	_c.Call.Return(_a0)
 	return _c
 }
 
-func (_c *Service_UpdateMessage_Call) RunAndReturn(run func(context.Context, int, model.MessageStatus) error) *Service_UpdateMessage_Call {
+func (_c *Service_RenewLease_Call) RunAndReturn(run func(context.Context, model.Message, time.Duration) error) *Service_RenewLease_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+// SendMessage provides a mock function with given fields: ctx, _a1
+func (_m *Service) SendMessage(ctx context.Context, _a1 message.MessageRequest) (*message.SendResult, error) {
+	ret := _m.Called(ctx, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SendMessage")
+	}
+
+	var r0 *message.SendResult
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, message.MessageRequest) (*message.SendResult, error)); ok {
+		return rf(ctx, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, message.MessageRequest) *message.SendResult); ok {
+		r0 = rf(ctx, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*message.SendResult)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, message.MessageRequest) error); ok {
+		r1 = rf(ctx, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Service_SendMessage_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'SendMessage'
+type Service_SendMessage_Call struct {
+	*mock.Call
+}
+
+// SendMessage is a helper method to define mock.On call
+//   - ctx context.Context
+//   - _a1 message.MessageRequest
+func (_e *Service_Expecter) SendMessage(ctx interface{}, _a1 interface{}) *Service_SendMessage_Call {
+	return &Service_SendMessage_Call{Call: _e.mock.On("SendMessage", ctx, _a1)}
+}
+
+func (_c *Service_SendMessage_Call) Run(run func(ctx context.Context, _a1 message.MessageRequest)) *Service_SendMessage_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(message.MessageRequest))
+	})
+	return _c
+}
+
+func (_c *Service_SendMessage_Call) Return(_a0 *message.SendResult, _a1 error) *Service_SendMessage_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *Service_SendMessage_Call) RunAndReturn(run func(context.Context, message.MessageRequest) (*message.SendResult, error)) *Service_SendMessage_Call {
 	_c.Call.Return(run)
 	return _c
 }